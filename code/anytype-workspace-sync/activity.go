@@ -0,0 +1,109 @@
+package anytypesync
+
+import (
+	"sync"
+	"time"
+)
+
+// Activity kinds
+const (
+	ActivitySynced  = "synced"
+	ActivityRemoved = "removed"
+	ActivityQueued  = "queued"
+	ActivityFailed  = "failed"
+	ActivityPaused  = "paused"
+	ActivityResumed = "resumed"
+)
+
+// Activity is one thing the engine did, as streamed by the control API
+type Activity struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Path     string    `json:"path,omitempty"`
+	ObjectID string    `json:"objectId,omitempty"`
+	Op       string    `json:"op,omitempty"`     // queued operation
+	Error    string    `json:"error,omitempty"`  // why a file failed
+	Detail   string    `json:"detail,omitempty"` // why sync paused, or what piled up by the time it resumed
+}
+
+// activityHub fans engine activity out to subscribers
+type activityHub struct {
+	mu          sync.Mutex
+	subscribers map[chan Activity]struct{}
+	dropped     int // activities a full subscriber channel could not take
+}
+
+// SubscribeActivity returns a channel that receives everything the engine does,
+// and a function that ends the subscription and closes the channel. Activity is
+// dropped for a subscriber whose buffer is full.
+func (e *Engine) SubscribeActivity(buffer int) (<-chan Activity, func()) {
+	ch := make(chan Activity, buffer)
+
+	e.activity.mu.Lock()
+	if e.activity.subscribers == nil {
+		e.activity.subscribers = make(map[chan Activity]struct{})
+	}
+	e.activity.subscribers[ch] = struct{}{}
+	e.activity.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.activity.mu.Lock()
+			delete(e.activity.subscribers, ch)
+			e.activity.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish hands an activity to every subscriber without blocking
+func (e *Engine) publish(a Activity) {
+	a.Time = time.Now()
+
+	e.activity.mu.Lock()
+	defer e.activity.mu.Unlock()
+
+	for ch := range e.activity.subscribers {
+		select {
+		case ch <- a:
+		default:
+			e.activity.dropped++
+			if e.activity.dropped == 1 || e.activity.dropped%100 == 0 {
+				engineLog.Warn("Activity subscriber is not keeping up", "dropped", e.activity.dropped)
+			}
+		}
+	}
+}
+
+// synced reports a file created or updated in AnyType
+func (e *Engine) synced(path, objectID string) {
+	e.publish(Activity{Kind: ActivitySynced, Path: path, ObjectID: objectID})
+	if e.hooks.Synced != nil {
+		e.hooks.Synced(path, objectID)
+	}
+}
+
+// removed reports a file whose object was taken out of use
+func (e *Engine) removed(path, objectID string) {
+	e.publish(Activity{Kind: ActivityRemoved, Path: path, ObjectID: objectID})
+	if e.hooks.Removed != nil {
+		e.hooks.Removed(path, objectID)
+	}
+}
+
+// queued reports an operation waiting for AnyType
+func (e *Engine) queued(op PendingOp) {
+	e.publish(Activity{Kind: ActivityQueued, Path: op.Path, Op: op.Op})
+	if e.hooks.Queued != nil {
+		e.hooks.Queued(op)
+	}
+}
+
+// failed reports a file that could not be synced or removed
+func (e *Engine) failed(path string, err error) {
+	e.publish(Activity{Kind: ActivityFailed, Path: path, Error: err.Error()})
+	if e.hooks.Failed != nil {
+		e.hooks.Failed(path, err)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package anytypesync
 
 import (
 	"context"
@@ -8,16 +8,70 @@ import (
 	"time"
 
 	"github.com/anyproto/anytype-heart/pb"
-	"github.com/anyproto/anytype-heart/pkg/lib/pb/model"
 	"github.com/anyproto/anytype-heart/pb/service"
+	"github.com/anyproto/anytype-heart/pkg/lib/pb/model"
 	"github.com/gogo/protobuf/types"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
-// openSpaceRPC opens a space via gRPC so we can create objects in it
-func (c *AnyTypeClient) openSpaceRPC(ctx context.Context, spaceID string) error {
-	fmt.Printf("[%s] gRPC: Opening workspace/space %s...\n", time.Now().Format(time.RFC3339), spaceID)
+// createSessionRPC invokes WalletCreateSession RPC to obtain a new session token.
+// The request carries its own credentials, so no token is attached.
+func (c *AnyTypeClient) createSessionRPC(ctx context.Context, auth pb.IsRpcWalletCreateSessionRequestAuth) (string, error) {
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	req := &pb.RpcWalletCreateSessionRequest{
+		Auth: auth,
+	}
+
+	// Call WalletCreateSession RPC
+	resp, err := client.WalletCreateSession(ctx, req)
+	if err != nil {
+		return "", c.handleGRPCError("WalletCreateSession", err)
+	}
+
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcWalletCreateSessionResponseError_NULL {
+		return "", responseError("WalletCreateSession", resp.Error.Code, resp.Error.Description)
+	}
+
+	if resp.Token == "" {
+		return "", fmt.Errorf("WalletCreateSession returned an empty token")
+	}
+	return resp.Token, nil
+}
+
+// createAppKeyRPC invokes AccountLocalLinkCreateApp RPC to register this tool as an app
+func (c *AnyTypeClient) createAppKeyRPC(ctx context.Context, appName string) (string, error) {
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// Add authentication to context
+	ctx = c.withAuth(ctx)
+
+	req := &pb.RpcAccountLocalLinkCreateAppRequest{
+		App: &model.AccountAuthAppInfo{
+			AppName: appName,
+			Scope:   model.AccountAuth_Full,
+		},
+	}
+
+	// Call AccountLocalLinkCreateApp RPC
+	resp, err := client.AccountLocalLinkCreateApp(ctx, req)
+	if err != nil {
+		return "", c.handleGRPCError("AccountLocalLinkCreateApp", err)
+	}
+
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcAccountLocalLinkCreateAppResponseError_NULL {
+		return "", responseError("AccountLocalLinkCreateApp", resp.Error.Code, resp.Error.Description)
+	}
+	return resp.AppKey, nil
+}
+
+// openSpaceRPC opens a space via gRPC so we can create objects in it, and returns
+// the account's profile object ID from the space info
+func (c *AnyTypeClient) openSpaceRPC(ctx context.Context, spaceID string) (string, error) {
+	apiLog.Debug("Opening space", "rpc", "WorkspaceOpen", "space_id", spaceID)
 
 	// Create gRPC client stub
 	client := service.NewClientCommandsClient(c.conn)
@@ -33,16 +87,94 @@ func (c *AnyTypeClient) openSpaceRPC(ctx context.Context, spaceID string) error
 	// Call WorkspaceOpen RPC
 	resp, err := client.WorkspaceOpen(ctx, req)
 	if err != nil {
-		return c.handleGRPCError(err)
+		return "", c.handleGRPCError("WorkspaceOpen", err)
 	}
 
 	// Check response error
 	if resp.Error != nil && resp.Error.Code != pb.RpcWorkspaceOpenResponseError_NULL {
-		return fmt.Errorf("WorkspaceOpen failed: %s (%s)", resp.Error.Description, resp.Error.Code)
+		return "", responseError("WorkspaceOpen", resp.Error.Code, resp.Error.Description)
 	}
 
-	fmt.Printf("[%s] gRPC: Workspace/space opened successfully\n", time.Now().Format(time.RFC3339))
-	return nil
+	apiLog.Info("Space opened", "rpc", "WorkspaceOpen", "space_id", spaceID)
+	return resp.Info.GetProfileObjectId(), nil
+}
+
+// appVersionRPC invokes AppGetVersion RPC, the cheapest call that proves the server answers
+func (c *AnyTypeClient) appVersionRPC(ctx context.Context) (string, error) {
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// Add authentication to context
+	ctx = c.withAuth(ctx)
+
+	// Call AppGetVersion RPC
+	resp, err := client.AppGetVersion(ctx, &pb.RpcAppGetVersionRequest{})
+	if err != nil {
+		return "", c.handleGRPCError("AppGetVersion", err)
+	}
+
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcAppGetVersionResponseError_NULL {
+		return "", responseError("AppGetVersion", resp.Error.Code, resp.Error.Description)
+	}
+	return resp.Version, nil
+}
+
+// listenEventsRPC opens the session event stream, on which the server pushes
+// changes made by any client of the account
+func (c *AnyTypeClient) listenEventsRPC(ctx context.Context) (service.ClientCommands_ListenSessionEventsClient, error) {
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// The stream is bound to the session named in the request
+	token := c.token(ctx)
+	ctx = c.withAuth(ctx)
+
+	// Call ListenSessionEvents RPC
+	stream, err := client.ListenSessionEvents(ctx, &pb.StreamRequest{Token: token})
+	if err != nil {
+		return nil, c.handleGRPCError("ListenSessionEvents", err)
+	}
+	return stream, nil
+}
+
+// spacePermissionsRPC looks up this account's participant record in a space via
+// ObjectSearch. found is false when the space has no record for the profile,
+// which happens when the space is not loaded.
+func (c *AnyTypeClient) spacePermissionsRPC(ctx context.Context, spaceID string, profileID string) (perms model.ParticipantPermissions, found bool, err error) {
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// Add authentication to context
+	ctx = c.withAuth(ctx)
+
+	req := &pb.RpcObjectSearchRequest{
+		SpaceId: spaceID,
+		Filters: []*model.BlockContentDataviewFilter{{
+			RelationKey: "identityProfileLink",
+			Condition:   model.BlockContentDataviewFilter_Equal,
+			Value:       stringValue(profileID),
+		}},
+		Keys:  []string{"id", "participantPermissions"},
+		Limit: 1,
+	}
+
+	// Call ObjectSearch RPC
+	resp, err := client.ObjectSearch(ctx, req)
+	if err != nil {
+		return 0, false, c.handleGRPCError("ObjectSearch", err)
+	}
+
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcObjectSearchResponseError_NULL {
+		return 0, false, responseError("ObjectSearch", resp.Error.Code, resp.Error.Description)
+	}
+
+	if len(resp.Records) == 0 {
+		return 0, false, nil
+	}
+	fields := resp.Records[0].GetFields()
+	return model.ParticipantPermissions(fields["participantPermissions"].GetNumberValue()), true, nil
 }
 
 // SyncMarkdownToAnyType creates or updates a page in AnyType from markdown
@@ -51,21 +183,21 @@ func (c *AnyTypeClient) SyncMarkdownToAnyType(ctx context.Context, change *FileC
 		return "", fmt.Errorf("not connected to AnyType")
 	}
 
-	fmt.Printf("[%s] gRPC: Creating/updating '%s' in AnyType\n", time.Now().Format(time.RFC3339), change.Title)
+	apiLog.Debug("Creating object for markdown", "path", change.Path, "space_id", spaceID)
 
 	// Create a new object (page) in the space with title and content
-	objectID, err := c.createObject(ctx, change.Title, change.Content, spaceID)
+	objectID, err := c.createObject(ctx, change.Title, change.Content, change.Path, spaceID)
 	if err != nil {
 		return "", fmt.Errorf("failed to create object: %w", err)
 	}
 
-	fmt.Printf("[%s] gRPC: Created/updated object '%s' successfully (ID: %s)\n", time.Now().Format(time.RFC3339), change.Title, objectID)
+	apiLog.Debug("Created object for markdown", "path", change.Path, "object_id", objectID)
 	return objectID, nil
 }
 
 // createObject invokes ObjectCreate RPC to create a new AnyType object
-func (c *AnyTypeClient) createObject(ctx context.Context, title string, content string, spaceID string) (string, error) {
-	fmt.Printf("[%s]   → Creating object: title='%s', content_len=%d bytes\n", time.Now().Format(time.RFC3339), title, len(content))
+func (c *AnyTypeClient) createObject(ctx context.Context, title string, content string, sourcePath string, spaceID string) (string, error) {
+	apiLog.Debug("Creating object", "rpc", "ObjectCreate", "title", title, "content_len", len(content))
 
 	// Create gRPC client stub
 	client := service.NewClientCommandsClient(c.conn)
@@ -86,37 +218,39 @@ func (c *AnyTypeClient) createObject(ctx context.Context, title string, content
 					StringValue: content,
 				},
 			},
+			// Marks the object as created by this tool so reconcile can find it
+			"sourceFilePath": stringValue(sourcePath),
 		},
 	}
 
 	// Create request with space ID and object details
 	// Use "ot-note" as the object type (AnyType note type)
 	req := &pb.RpcObjectCreateRequest{
-		SpaceId:              spaceID,
-		Details:              details,
-		ObjectTypeUniqueKey:  "ot-note",
-		InternalFlags:        nil,
+		SpaceId:             spaceID,
+		Details:             details,
+		ObjectTypeUniqueKey: "ot-note",
+		InternalFlags:       nil,
 	}
 
 	// Call ObjectCreate RPC
 	resp, err := client.ObjectCreate(ctx, req)
 	if err != nil {
-		return "", c.handleGRPCError(err)
+		return "", c.handleGRPCError("ObjectCreate", err)
 	}
 
 	// Check response error
 	if resp.Error != nil && resp.Error.Code != pb.RpcObjectCreateResponseError_NULL {
-		return "", fmt.Errorf("ObjectCreate failed: %s (%s)", resp.Error.Description, resp.Error.Code)
+		return "", responseError("ObjectCreate", resp.Error.Code, resp.Error.Description)
 	}
 
 	objectID := resp.ObjectId
-	fmt.Printf("[%s]   → Created object ID: %s\n", time.Now().Format(time.RFC3339), objectID)
+	apiLog.Debug("Created object", "rpc", "ObjectCreate", "object_id", objectID)
 	return objectID, nil
 }
 
 // deleteObject invokes ObjectListDelete RPC to delete an AnyType object
 func (c *AnyTypeClient) deleteObject(ctx context.Context, objectID string) error {
-	fmt.Printf("[%s]   → Deleting object ID: %s\n", time.Now().Format(time.RFC3339), objectID)
+	apiLog.Debug("Deleting object", "rpc", "ObjectListDelete", "object_id", objectID)
 
 	// Create gRPC client stub
 	client := service.NewClientCommandsClient(c.conn)
@@ -132,15 +266,226 @@ func (c *AnyTypeClient) deleteObject(ctx context.Context, objectID string) error
 	// Call ObjectListDelete RPC
 	resp, err := client.ObjectListDelete(ctx, req)
 	if err != nil {
-		return c.handleGRPCError(err)
+		return c.handleGRPCError("ObjectListDelete", err)
 	}
 
 	// Check response error
 	if resp.Error != nil && resp.Error.Code != pb.RpcObjectListDeleteResponseError_NULL {
-		return fmt.Errorf("ObjectListDelete failed: %s (%s)", resp.Error.Description, resp.Error.Code)
+		return responseError("ObjectListDelete", resp.Error.Code, resp.Error.Description)
+	}
+
+	apiLog.Debug("Deleted object", "rpc", "ObjectListDelete", "object_id", objectID)
+	return nil
+}
+
+// RemoteObject is an AnyType object as returned by ObjectSearch
+type RemoteObject struct {
+	ID         string
+	Name       string
+	SourcePath string // sourceFilePath detail, set on objects created by this tool
+	Layout     model.ObjectTypeLayout
+	Created    time.Time
+}
+
+// searchKeys are the details requested from ObjectSearch
+var searchKeys = []string{"id", "name", "sourceFilePath", "layout", "createdDate", "isDeleted"}
+
+// searchObjects invokes ObjectSearch RPC and returns the matching objects
+func (c *AnyTypeClient) searchObjects(ctx context.Context, spaceID string, filters []*model.BlockContentDataviewFilter) ([]RemoteObject, error) {
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// Add authentication to context
+	ctx = c.withAuth(ctx)
+
+	req := &pb.RpcObjectSearchRequest{
+		SpaceId: spaceID,
+		Filters: filters,
+		Keys:    searchKeys,
+	}
+
+	// Call ObjectSearch RPC
+	resp, err := client.ObjectSearch(ctx, req)
+	if err != nil {
+		return nil, c.handleGRPCError("ObjectSearch", err)
+	}
+
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcObjectSearchResponseError_NULL {
+		return nil, responseError("ObjectSearch", resp.Error.Code, resp.Error.Description)
+	}
+
+	objects := make([]RemoteObject, 0, len(resp.Records))
+	for _, record := range resp.Records {
+		fields := record.GetFields()
+		if fields["isDeleted"].GetBoolValue() {
+			continue
+		}
+		objects = append(objects, RemoteObject{
+			ID:         fields["id"].GetStringValue(),
+			Name:       fields["name"].GetStringValue(),
+			SourcePath: fields["sourceFilePath"].GetStringValue(),
+			Layout:     model.ObjectTypeLayout(fields["layout"].GetNumberValue()),
+			Created:    time.Unix(int64(fields["createdDate"].GetNumberValue()), 0),
+		})
+	}
+
+	apiLog.Debug("Search finished", "rpc", "ObjectSearch", "objects", len(objects))
+	return objects, nil
+}
+
+// stringValue wraps a string in a protobuf value
+func stringValue(s string) *types.Value {
+	return &types.Value{Kind: &types.Value_StringValue{StringValue: s}}
+}
+
+// updateObject invokes ObjectSetDetails RPC to replace the title and content of an existing object
+func (c *AnyTypeClient) updateObject(ctx context.Context, objectID string, title string, content string) error {
+	apiLog.Debug("Updating object", "rpc", "ObjectSetDetails", "object_id", objectID, "title", title, "content_len", len(content))
+
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// Add authentication to context
+	ctx = c.withAuth(ctx)
+
+	req := &pb.RpcObjectSetDetailsRequest{
+		ContextId: objectID,
+		Details: []*model.Detail{
+			{Key: "name", Value: stringValue(title)},
+			{Key: "description", Value: stringValue(content)},
+		},
+	}
+
+	// Call ObjectSetDetails RPC
+	resp, err := client.ObjectSetDetails(ctx, req)
+	if err != nil {
+		return c.handleGRPCError("ObjectSetDetails", err)
+	}
+
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcObjectSetDetailsResponseError_NULL {
+		return responseError("ObjectSetDetails", resp.Error.Code, resp.Error.Description)
+	}
+
+	apiLog.Debug("Updated object", "rpc", "ObjectSetDetails", "object_id", objectID)
+	return nil
+}
+
+// setArchived invokes ObjectListSetIsArchived RPC to move objects to or out of the bin
+func (c *AnyTypeClient) setArchived(ctx context.Context, objectIDs []string, archived bool) error {
+	apiLog.Debug("Setting archive state", "rpc", "ObjectListSetIsArchived", "archived", archived, "objects", len(objectIDs))
+
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// Add authentication to context
+	ctx = c.withAuth(ctx)
+
+	req := &pb.RpcObjectListSetIsArchivedRequest{
+		ObjectIds:  objectIDs,
+		IsArchived: archived,
+	}
+
+	// Call ObjectListSetIsArchived RPC
+	resp, err := client.ObjectListSetIsArchived(ctx, req)
+	if err != nil {
+		return c.handleGRPCError("ObjectListSetIsArchived", err)
+	}
+
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcObjectListSetIsArchivedResponseError_NULL {
+		return responseError("ObjectListSetIsArchived", resp.Error.Code, resp.Error.Description)
+	}
+
+	apiLog.Debug("Updated archive state", "rpc", "ObjectListSetIsArchived", "archived", archived, "objects", len(objectIDs))
+	return nil
+}
+
+// createCollection invokes ObjectCreate RPC to create an empty collection
+func (c *AnyTypeClient) createCollection(ctx context.Context, name string, spaceID string) (string, error) {
+	apiLog.Debug("Creating collection", "rpc", "ObjectCreate", "name", name)
+
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// Add authentication to context
+	ctx = c.withAuth(ctx)
+
+	req := &pb.RpcObjectCreateRequest{
+		SpaceId: spaceID,
+		Details: &types.Struct{
+			Fields: map[string]*types.Value{
+				"name": stringValue(name),
+			},
+		},
+		ObjectTypeUniqueKey: "ot-collection",
+	}
+
+	// Call ObjectCreate RPC
+	resp, err := client.ObjectCreate(ctx, req)
+	if err != nil {
+		return "", c.handleGRPCError("ObjectCreate", err)
+	}
+
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcObjectCreateResponseError_NULL {
+		return "", responseError("ObjectCreate", resp.Error.Code, resp.Error.Description)
+	}
+
+	apiLog.Debug("Created collection", "rpc", "ObjectCreate", "object_id", resp.ObjectId)
+	return resp.ObjectId, nil
+}
+
+// collectionAdd invokes ObjectCollectionAdd RPC to add objects to a collection
+func (c *AnyTypeClient) collectionAdd(ctx context.Context, collectionID string, objectIDs []string) error {
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// Add authentication to context
+	ctx = c.withAuth(ctx)
+
+	req := &pb.RpcObjectCollectionAddRequest{
+		ContextId: collectionID,
+		ObjectIds: objectIDs,
+	}
+
+	// Call ObjectCollectionAdd RPC
+	resp, err := client.ObjectCollectionAdd(ctx, req)
+	if err != nil {
+		return c.handleGRPCError("ObjectCollectionAdd", err)
 	}
 
-	fmt.Printf("[%s]   → Successfully deleted object\n", time.Now().Format(time.RFC3339))
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcObjectCollectionAddResponseError_NULL {
+		return responseError("ObjectCollectionAdd", resp.Error.Code, resp.Error.Description)
+	}
+	return nil
+}
+
+// collectionRemove invokes ObjectCollectionRemove RPC to take objects out of a collection
+func (c *AnyTypeClient) collectionRemove(ctx context.Context, collectionID string, objectIDs []string) error {
+	// Create gRPC client stub
+	client := service.NewClientCommandsClient(c.conn)
+
+	// Add authentication to context
+	ctx = c.withAuth(ctx)
+
+	req := &pb.RpcObjectCollectionRemoveRequest{
+		ContextId: collectionID,
+		ObjectIds: objectIDs,
+	}
+
+	// Call ObjectCollectionRemove RPC
+	resp, err := client.ObjectCollectionRemove(ctx, req)
+	if err != nil {
+		return c.handleGRPCError("ObjectCollectionRemove", err)
+	}
+
+	// Check response error
+	if resp.Error != nil && resp.Error.Code != pb.RpcObjectCollectionRemoveResponseError_NULL {
+		return responseError("ObjectCollectionRemove", resp.Error.Code, resp.Error.Description)
+	}
 	return nil
 }
 
@@ -167,7 +512,7 @@ func (c *AnyTypeClient) uploadFile(ctx context.Context, filePath string, spaceID
 	// Detect file type from extension
 	fileType := detectFileType(filePath)
 
-	fmt.Printf("[%s]   → Uploading file: %s (type: %s)\n", time.Now().Format(time.RFC3339), filePath, fileType)
+	apiLog.Debug("Uploading file", "rpc", "FileUpload", "path", filePath, "file_type", fileType.String())
 
 	// Create gRPC client stub
 	client := service.NewClientCommandsClient(c.conn)
@@ -180,53 +525,25 @@ func (c *AnyTypeClient) uploadFile(ctx context.Context, filePath string, spaceID
 		SpaceId:   spaceID,
 		LocalPath: filePath,
 		Type:      fileType,
+		Details: &types.Struct{
+			Fields: map[string]*types.Value{
+				"sourceFilePath": stringValue(filePath),
+			},
+		},
 	}
 
 	// Call FileUpload RPC
 	resp, err := client.FileUpload(ctx, req)
 	if err != nil {
-		return "", c.handleGRPCError(err)
+		return "", c.handleGRPCError("FileUpload", err)
 	}
 
 	// Check response error
 	if resp.Error != nil && resp.Error.Code != pb.RpcFileUploadResponseError_NULL {
-		return "", fmt.Errorf("FileUpload failed: %s (%s)", resp.Error.Description, resp.Error.Code)
+		return "", responseError("FileUpload", resp.Error.Code, resp.Error.Description)
 	}
 
 	objectID := resp.ObjectId
-	fmt.Printf("[%s]   → Uploaded file successfully (Object ID: %s)\n", time.Now().Format(time.RFC3339), objectID)
+	apiLog.Debug("Uploaded file", "rpc", "FileUpload", "path", filePath, "object_id", objectID)
 	return objectID, nil
 }
-
-// handleGRPCError provides detailed error messages for gRPC failures
-func (c *AnyTypeClient) handleGRPCError(err error) error {
-	if err == nil {
-		return nil
-	}
-
-	st, ok := status.FromError(err)
-	if !ok {
-		return fmt.Errorf("unknown gRPC error: %w", err)
-	}
-
-	switch st.Code() {
-	case codes.OK:
-		return nil
-	case codes.Unavailable:
-		return fmt.Errorf("AnyType service unavailable - check if AnyType is running")
-	case codes.DeadlineExceeded:
-		return fmt.Errorf("gRPC call timeout")
-	case codes.PermissionDenied:
-		return fmt.Errorf("permission denied - check credentials or space access")
-	case codes.NotFound:
-		return fmt.Errorf("space or object not found")
-	case codes.InvalidArgument:
-		return fmt.Errorf("invalid request: %s", st.Message())
-	case codes.Internal:
-		return fmt.Errorf("AnyType internal error: %s", st.Message())
-	case codes.Unauthenticated:
-		return fmt.Errorf("not authenticated - check network membership")
-	default:
-		return fmt.Errorf("gRPC error %s: %s", st.Code(), st.Message())
-	}
-}
@@ -0,0 +1,197 @@
+package anytypesync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// auditFile is the audit log file name under the state directory
+const auditFile = ".anytype-workspace-audit.jsonl"
+
+// What started a sync decision, as recorded in the audit log
+const (
+	TriggerInitial   = "initial"   // the sync of existing files at startup
+	TriggerWatch     = "watch"     // a change seen by the watcher
+	TriggerReplay    = "replay"    // a queued operation sent once AnyType was back
+	TriggerControl   = "control"   // a resync asked for over the control API
+	TriggerReconcile = "reconcile" // the reconcile subcommand
+	TriggerApproved  = "approved"  // an operator approved held deletions
+	TriggerSweep     = "sweep"     // the sweeper purging an object past retention
+	TriggerRemote    = "remote"    // a change made in AnyType
+	TriggerManual    = "manual"    // a program calling the engine without a trigger
+)
+
+// auditOpPurge is the op of an object destroyed for good, after its file was removed
+const auditOpPurge = "purge"
+
+// outcomeSkipped is the outcome of a decision to do nothing
+const outcomeSkipped = "skipped"
+
+// AuditEntry is one sync decision: a line of the audit log
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Path     string    `json:"path"`
+	Op       string    `json:"op"` // create, update, delete or purge
+	ObjectID string    `json:"objectId,omitempty"`
+	Hash     string    `json:"hash,omitempty"` // SHA-256 of the content sent
+	Trigger  string    `json:"trigger"`
+	Duration Duration  `json:"duration"`
+	Outcome  string    `json:"outcome"`          // success, error, queued, held or skipped
+	Detail   string    `json:"detail,omitempty"` // e.g. the delete policy applied
+	Error    string    `json:"error,omitempty"`
+}
+
+// AuditFilter selects audit entries; zero fields match everything
+type AuditFilter struct {
+	Path    string // the file's path, its path in the workspace, its name, or a glob of one
+	Since   time.Time
+	Until   time.Time
+	Outcome string
+}
+
+// auditLog appends entries to the rotated audit log file, opened on first use
+type auditLog struct {
+	mu   sync.Mutex
+	file *RotatingFile
+}
+
+// triggerKey is the context key of the audit trigger
+type triggerKey struct{}
+
+// WithTrigger labels the operations run with ctx for the audit log
+func WithTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+// triggerOf returns what started the operations run with ctx
+func triggerOf(ctx context.Context) string {
+	if trigger, ok := ctx.Value(triggerKey{}).(string); ok {
+		return trigger
+	}
+	return TriggerManual
+}
+
+// audit records a sync decision that started at start. Failing to record one is
+// logged; it does not fail the sync.
+func (e *Engine) audit(ctx context.Context, start time.Time, entry AuditEntry, err error) {
+	if !e.config.Audit.Enabled {
+		return
+	}
+
+	entry.Time = time.Now()
+	entry.Trigger = triggerOf(ctx)
+	entry.Duration = Duration(entry.Time.Sub(start).Round(time.Millisecond))
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	data, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		return
+	}
+
+	e.auditLog.mu.Lock()
+	defer e.auditLog.mu.Unlock()
+
+	if e.auditLog.file == nil {
+		file, openErr := OpenRotatingFile(e.config.statePath(auditFile), int64(e.config.Audit.MaxSizeMB)<<20, e.config.Audit.Backups)
+		if openErr != nil {
+			engineLog.Warn("Failed to open audit log", "error", openErr)
+			return
+		}
+		e.auditLog.file = file
+	}
+	if _, writeErr := e.auditLog.file.Write(append(data, '\n')); writeErr != nil {
+		engineLog.Warn("Failed to write audit log", "path", entry.Path, "error", writeErr)
+	}
+}
+
+// closeAudit closes the audit log; the next entry opens it again
+func (e *Engine) closeAudit() {
+	e.auditLog.mu.Lock()
+	defer e.auditLog.mu.Unlock()
+
+	if e.auditLog.file != nil {
+		e.auditLog.file.Close()
+		e.auditLog.file = nil
+	}
+}
+
+// ReadAudit returns the entries of the audit log kept for config that match
+// filter, oldest first, rotated files included
+func ReadAudit(config *Config, filter AuditFilter) ([]AuditEntry, error) {
+	path := config.statePath(auditFile)
+
+	files := []string{path}
+	for i := 1; i <= config.Audit.Backups; i++ {
+		files = append([]string{fmt.Sprintf("%s.%d", path, i)}, files...)
+	}
+
+	var entries []AuditEntry
+	found := false
+	for _, name := range files {
+		file, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		found = true
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var entry AuditEntry
+			// A crash can leave a partial last line
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			if filter.matches(config, entry) {
+				entries = append(entries, entry)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no audit log at %s", path)
+	}
+	return entries, nil
+}
+
+// matches reports whether entry passes the filter
+func (f AuditFilter) matches(config *Config, entry AuditEntry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	if f.Outcome != "" && entry.Outcome != f.Outcome {
+		return false
+	}
+	if f.Path == "" {
+		return true
+	}
+
+	rel, err := filepath.Rel(config.WorkspaceDir, entry.Path)
+	if err != nil {
+		rel = entry.Path
+	}
+	for _, name := range []string{entry.Path, rel, filepath.Base(entry.Path)} {
+		if ok, _ := filepath.Match(f.Path, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen is returned without calling AnyType while the breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// String names the state for logs and status
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker stops calls to a wedged AnyType server. It opens when the failure
+// rate over the last WindowSize calls crosses FailureRate, rejects calls for
+// OpenDuration, then lets a few probe calls through (half-open) to decide whether
+// to close again.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	config      BreakerConfig
+	state       BreakerState
+	generation  uint64 // incremented on every transition
+	outcomes    []bool // recent call outcomes, true = failure
+	openedAt    time.Time
+	probes      int // probe calls in flight while half-open
+	transitions int
+	pending     []transition // transitions onChange has not been told about yet
+
+	notifyMu sync.Mutex // held while onChange runs, so transitions are reported in order
+	onChange func(from, to BreakerState)
+}
+
+// transition is a state change waiting to be reported
+type transition struct {
+	from, to BreakerState
+}
+
+// NewCircuitBreaker creates a closed breaker. onChange is called on every
+// transition, in order and outside the breaker's lock; it must not call Allow
+// or Record.
+func NewCircuitBreaker(config BreakerConfig, onChange func(from, to BreakerState)) *CircuitBreaker {
+	return &CircuitBreaker{config: config, onChange: onChange}
+}
+
+// Allow reports whether a call may go ahead. The returned generation is passed
+// to Record with the call's outcome.
+func (b *CircuitBreaker) Allow() (uint64, error) {
+	defer b.notify()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < time.Duration(b.config.OpenDuration) {
+			return 0, ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= b.config.HalfOpenProbes {
+			return 0, ErrCircuitOpen
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+// Record feeds the outcome of an allowed call back into the breaker. Calls
+// allowed before the last transition no longer count.
+func (b *CircuitBreaker) Record(generation uint64, failed bool) {
+	defer b.notify()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	if b.state == BreakerHalfOpen {
+		b.probes--
+		if failed {
+			b.open()
+		} else {
+			b.outcomes = nil
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.outcomes = append(b.outcomes, failed)
+	if len(b.outcomes) > b.config.WindowSize {
+		b.outcomes = b.outcomes[len(b.outcomes)-b.config.WindowSize:]
+	}
+
+	if b.state == BreakerClosed && len(b.outcomes) >= b.config.MinRequests && b.failureRate() >= b.config.FailureRate {
+		b.open()
+	}
+}
+
+// failureRate returns the share of failed calls in the window; callers must hold mu
+func (b *CircuitBreaker) failureRate() float64 {
+	if len(b.outcomes) == 0 {
+		return 0
+	}
+	failures := 0
+	for _, failed := range b.outcomes {
+		if failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(b.outcomes))
+}
+
+// open trips the breaker; callers must hold mu
+func (b *CircuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.outcomes = nil
+	b.setState(BreakerOpen)
+}
+
+// setState records a transition for notify to report; callers must hold mu
+func (b *CircuitBreaker) setState(to BreakerState) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	b.generation++
+	b.transitions++
+	if b.onChange != nil {
+		b.pending = append(b.pending, transition{from, to})
+	}
+}
+
+// notify reports pending transitions to onChange. It runs after mu is released
+// so the callback may query the breaker.
+func (b *CircuitBreaker) notify() {
+	b.notifyMu.Lock()
+	defer b.notifyMu.Unlock()
+
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	for _, t := range pending {
+		b.onChange(t.from, t.to)
+	}
+}
+
+// State returns the current state, the failure rate in the window and the number of transitions so far
+func (b *CircuitBreaker) State() (BreakerState, float64, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state, b.failureRate(), b.transitions
+}
+
+// Ready reports whether a call would currently be let through, without reserving a probe
+func (b *CircuitBreaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		return time.Since(b.openedAt) >= time.Duration(b.config.OpenDuration)
+	case BreakerHalfOpen:
+		return b.probes < b.config.HalfOpenProbes
+	default:
+		return true
+	}
+}
+
+// isBreakerFailure reports whether a call failed in a way that says the server is unhealthy.
+// Errors about the request itself (not found, bad input) do not count.
+func isBreakerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return err != nil && !errors.Is(err, context.Canceled)
+	default:
+		return false
+	}
+}
+
+// interceptor rejects calls while the breaker is open and records the outcome of the rest.
+// It sits outside the retry interceptor, so a call and its retries count once.
+func (b *CircuitBreaker) interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		generation, err := b.Allow()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path.Base(method), err)
+		}
+
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		b.Record(generation, isBreakerFailure(err))
+		return err
+	}
+}
@@ -0,0 +1,123 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	// Steps: "ok"/"fail" record the outcome of a call allowed in the current
+	// state, "allow"/"deny" expect Allow to pass or fail, "late-ok"/"late-fail"
+	// record the outcome of the oldest allowed call not recorded yet, and "wait"
+	// lets OpenDuration run out
+	tests := []struct {
+		name  string
+		steps []string
+		want  BreakerState
+	}{
+		{"stays closed below minRequests", []string{"fail", "fail", "fail"}, BreakerClosed},
+		{"stays closed below failure rate", []string{"fail", "ok", "ok", "ok", "fail", "ok"}, BreakerClosed},
+		{"opens at failure rate", []string{"ok", "fail", "ok", "fail"}, BreakerOpen},
+		{"rejects while open", []string{"fail", "fail", "fail", "fail", "deny", "deny"}, BreakerOpen},
+		{"half-open after openDuration", []string{"fail", "fail", "fail", "fail", "wait", "allow"}, BreakerHalfOpen},
+		{"half-open limits probes", []string{"fail", "fail", "fail", "fail", "wait", "allow", "deny"}, BreakerHalfOpen},
+		{"probe success closes", []string{"fail", "fail", "fail", "fail", "wait", "allow", "ok", "allow", "allow"}, BreakerClosed},
+		{"probe failure reopens", []string{"fail", "fail", "fail", "fail", "wait", "allow", "fail", "deny"}, BreakerOpen},
+		{"closed again starts a fresh window", []string{"fail", "fail", "fail", "fail", "wait", "allow", "ok", "fail", "fail", "fail"}, BreakerClosed},
+		{"only the window counts", []string{"ok", "ok", "ok", "ok", "ok", "ok", "fail", "fail", "fail"}, BreakerOpen},
+		{"probe outcome closes", []string{"fail", "fail", "fail", "fail", "wait", "allow", "late-ok"}, BreakerClosed},
+		{"calls from before opening are not probes", []string{"allow", "fail", "fail", "fail", "fail", "wait", "allow", "late-ok", "deny"}, BreakerHalfOpen},
+		{"calls from before opening can't reopen", []string{"allow", "fail", "fail", "fail", "fail", "wait", "allow", "ok", "late-fail", "late-fail", "ok"}, BreakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var transitions [][2]BreakerState
+			b := NewCircuitBreaker(BreakerConfig{
+				WindowSize:     6,
+				MinRequests:    4,
+				FailureRate:    0.5,
+				OpenDuration:   Duration(time.Hour),
+				HalfOpenProbes: 1,
+			}, func(from, to BreakerState) { transitions = append(transitions, [2]BreakerState{from, to}) })
+
+			var allowed []uint64 // generations of allowed calls not recorded yet
+			for i, step := range tt.steps {
+				switch step {
+				case "ok", "fail":
+					b.mu.Lock()
+					generation := b.generation
+					b.mu.Unlock()
+					b.Record(generation, step == "fail")
+				case "late-ok", "late-fail":
+					b.Record(allowed[0], step == "late-fail")
+					allowed = allowed[1:]
+				case "allow", "deny":
+					generation, err := b.Allow()
+					if err == nil {
+						allowed = append(allowed, generation)
+					}
+					if (err == nil) != (step == "allow") {
+						t.Fatalf("step %d: Allow() = %v, want %s", i, err, step)
+					}
+					if err != nil && !errors.Is(err, ErrCircuitOpen) {
+						t.Fatalf("step %d: Allow() = %v, want ErrCircuitOpen", i, err)
+					}
+				case "wait":
+					b.mu.Lock()
+					b.openedAt = b.openedAt.Add(-time.Duration(b.config.OpenDuration))
+					b.mu.Unlock()
+					if !b.Ready() {
+						t.Fatalf("step %d: not ready after openDuration", i)
+					}
+				}
+			}
+
+			state, _, count := b.State()
+			if state != tt.want {
+				t.Errorf("state = %s, want %s", state, tt.want)
+			}
+			// onChange has been told about every transition, in order
+			if len(transitions) != count {
+				t.Fatalf("onChange called for %d of %d transitions", len(transitions), count)
+			}
+			from := BreakerClosed
+			for _, tr := range transitions {
+				if tr[0] != from {
+					t.Errorf("transitions reported as %v", transitions)
+					break
+				}
+				from = tr[1]
+			}
+			if from != state {
+				t.Errorf("last reported transition is to %s, want %s", from, state)
+			}
+		})
+	}
+}
+
+func TestIsBreakerFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{status.Error(codes.Unavailable, "down"), true},
+		{status.Error(codes.DeadlineExceeded, "slow"), true},
+		{status.Error(codes.Internal, "panic"), true},
+		{status.Error(codes.Unknown, "?"), true},
+		{status.Error(codes.NotFound, "gone"), false},
+		{status.Error(codes.InvalidArgument, "bad"), false},
+		{status.Error(codes.Unauthenticated, "token"), false},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := isBreakerFailure(tt.err); got != tt.want {
+			t.Errorf("isBreakerFailure(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
@@ -1,74 +1,147 @@
-package main
+package anytypesync
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/anyproto/anytype-heart/pb"
+	"github.com/anyproto/anytype-heart/pkg/lib/pb/model"
+	"github.com/gogo/protobuf/types"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 )
 
 // AnyTypeClient wraps gRPC connection to AnyType
 type AnyTypeClient struct {
-	conn          *grpc.ClientConn
-	addr          string
-	sessionToken  string
-	refreshMutex  sync.Mutex // Prevent concurrent token refreshes
-	lastRefresh   time.Time  // Track when we last refreshed
-	anytypeBinary string     // Path to anytype binary
+	conn           *grpc.ClientConn
+	addr           string
+	spaceID        string        // Space opened with OpenSpace, guarded by spaceMu
+	profileID      string        // Account profile object ID from the opened space, guarded by spaceMu
+	spaceMu        sync.RWMutex  // Guards spaceID and profileID
+	tokens         *tokenManager // Session token and its renewal
+	appKeyFile     string        // App key used to create sessions
+	accountKeyFile string        // Account key used when there is no app key
+	restartServer  bool          // Allow restarting the server as a last resort
+	anytypeBinary  string        // Path to anytype binary
+	config         *Config
+	breaker        *CircuitBreaker
+	health         *HealthMonitor
+	supervisor     *Supervisor // Restarts the server when set, instead of pkill
+	recovered      func()      // Called when AnyType becomes reachable again
+	events         eventHub    // Subscribers to the session event stream
 }
 
-// NewAnyTypeClient creates a new gRPC client for AnyType
-func NewAnyTypeClient(addr string) (*AnyTypeClient, error) {
+// NewAnyTypeClient creates a new gRPC client for the AnyType server at config.GRPCAddr.
+// opts are added to the dial options, e.g. a dialer for an in-process test server.
+// It does not wait for the server: the connection is made in the background and
+// calls fail as unavailable until it is up, so the client can be created while
+// AnyType is still starting and the health monitor picks the server up later.
+func NewAnyTypeClient(config *Config, opts ...grpc.DialOption) (*AnyTypeClient, error) {
+	addr := config.GRPCAddr
 	client := &AnyTypeClient{
-		addr:          addr,
-		anytypeBinary: "/root/.local/bin/anytype", // Default path
+		addr:           addr,
+		appKeyFile:     config.Auth.AppKeyFile,
+		accountKeyFile: config.Auth.AccountKeyFile,
+		restartServer:  config.Auth.RestartServer,
+		anytypeBinary:  config.Auth.AnytypeBinary,
+		config:         config,
 	}
+	client.breaker = NewCircuitBreaker(config.Breaker, client.onBreakerChange)
+	client.health = NewHealthMonitor()
 
-	// Read session token from config
-	token, err := readSessionToken()
+	// Take the session token from the environment, the token file or the CLI config
+	provider, err := newTokenProvider(config.Auth)
 	if err != nil {
-		fmt.Printf("Warning: failed to read session token: %v\n", err)
-	} else {
-		client.sessionToken = token
+		return nil, err
+	}
+	client.tokens = newTokenManager(provider)
+	if client.tokens.peek() == "" {
+		authLog.Warn("No session token yet; one will be created, or picked up when it appears")
 	}
 
-	// Connect to gRPC server
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	creds, err := transportCredentials(config.TLS)
+	if err != nil {
+		return nil, err
+	}
 
-	conn, err := grpc.DialContext(
-		ctx,
-		addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithBlock(),
-	)
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(client.breaker.interceptor(), retryInterceptor(config.Retry), logInterceptor(), metricsInterceptor()),
+	}
+	dialOpts = append(dialOpts, keepaliveOptions(config.Keepalive)...)
+	conn, err := grpc.DialContext(context.Background(), addr, append(dialOpts, opts...)...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to AnyType gRPC: %w", err)
+		return nil, fmt.Errorf("failed to set up AnyType gRPC client: %w", err)
 	}
 
 	client.conn = conn
 	return client, nil
 }
 
+// SetSupervisor makes the client restart the server through s when it has to
+func (c *AnyTypeClient) SetSupervisor(s *Supervisor) {
+	c.supervisor = s
+}
+
+// OnRecovered registers fn to run whenever AnyType becomes reachable again
+func (c *AnyTypeClient) OnRecovered(fn func()) {
+	c.recovered = fn
+}
+
+// notifyRecovered calls the OnRecovered callback, if any
+func (c *AnyTypeClient) notifyRecovered() {
+	if c.recovered != nil {
+		c.recovered()
+	}
+}
+
+// onBreakerChange logs breaker transitions and replays the queue once calls flow again
+func (c *AnyTypeClient) onBreakerChange(from, to BreakerState) {
+	switch to {
+	case BreakerOpen:
+		clientLog.Warn("Circuit breaker opened, pausing AnyType calls", "from", from, "to", to, "duration", time.Duration(c.config.Breaker.OpenDuration))
+	case BreakerHalfOpen:
+		clientLog.Info("Circuit breaker half-open, probing AnyType", "from", from, "to", to)
+	case BreakerClosed:
+		clientLog.Info("Circuit breaker closed, AnyType calls resumed", "from", from, "to", to)
+		c.notifyRecovered()
+	}
+}
+
+// BreakerState returns the state of the client's circuit breaker and its failure
+// rate; a nil client counts as open
+func (c *AnyTypeClient) BreakerState() (BreakerState, float64) {
+	if c == nil || c.breaker == nil {
+		return BreakerOpen, 1
+	}
+	state, failureRate, _ := c.breaker.State()
+	return state, failureRate
+}
+
+// Ready reports whether the breaker would let a call through right now
+func (c *AnyTypeClient) Ready() bool {
+	return c.breaker.Ready()
+}
+
 // withAuth adds authentication metadata to context
 func (c *AnyTypeClient) withAuth(ctx context.Context) context.Context {
-	if c.sessionToken != "" {
-		return metadata.AppendToOutgoingContext(ctx, "token", c.sessionToken)
+	if token := c.token(ctx); token != "" {
+		return metadata.AppendToOutgoingContext(ctx, "token", token)
 	}
 	return ctx
 }
 
 // readSessionToken reads the session token from AnyType config
 func readSessionToken() (string, error) {
-	configPath := os.Getenv("HOME") + "/.anytype/config.json"
+	configPath := cliConfigPath()
 
 	data, err := os.ReadFile(configPath)
 	if err != nil {
@@ -88,50 +161,113 @@ func readSessionToken() (string, error) {
 
 // isAuthError checks if an error is an authentication error
 func isAuthError(err error) bool {
+	return errors.Is(err, ErrUnauthenticated)
+}
+
+// refreshToken replaces stale, the token the server rejected, with a new one.
+// Concurrent callers share a single renewal, and a caller whose token was already
+// replaced returns at once.
+func (c *AnyTypeClient) refreshToken(ctx context.Context, stale string) error {
+	return c.tokens.renew(ctx, stale, c.newToken)
+}
+
+// newToken obtains a new session token, preferring a fresh session from the
+// running server and only restarting it when that fails and restarts are enabled
+func (c *AnyTypeClient) newToken(ctx context.Context) (string, error) {
+	authLog.Info("Renewing session token")
+
+	token, err := c.createSession(ctx)
 	if err == nil {
-		return false
+		authLog.Info("New session created")
+		return token, nil
+	}
+	authLog.Warn("Could not create session", "error", err)
+
+	if !c.restartServer {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	return c.restartServerForToken()
+}
+
+// createSession asks the running server for a new session token using the
+// configured app key, falling back to the account key
+func (c *AnyTypeClient) createSession(ctx context.Context) (string, error) {
+	if appKey, err := readKeyFile(c.appKeyFile); err == nil {
+		authLog.Debug("Creating session with app key")
+		return c.createSessionRPC(ctx, &pb.RpcWalletCreateSessionRequestAuthOfAppKey{AppKey: appKey})
+	}
+
+	if accountKey, err := readKeyFile(c.accountKeyFile); err == nil {
+		authLog.Debug("Creating session with account key")
+		return c.createSessionRPC(ctx, &pb.RpcWalletCreateSessionRequestAuthOfAccountKey{AccountKey: accountKey})
+	}
+
+	return "", fmt.Errorf("no app key or account key available (run 'anytype-workspace-sync app-key')")
+}
+
+// CreateAppKey registers this tool with the account and returns an app key
+// that can later be exchanged for session tokens
+func (c *AnyTypeClient) CreateAppKey(ctx context.Context, appName string) (string, error) {
+	if c.conn == nil {
+		return "", fmt.Errorf("gRPC client not connected")
 	}
-	errMsg := strings.ToLower(err.Error())
-	return strings.Contains(errMsg, "not authenticated") ||
-		strings.Contains(errMsg, "authentication failed") ||
-		strings.Contains(errMsg, "invalid token") ||
-		strings.Contains(errMsg, "session") ||
-		strings.Contains(errMsg, "signature is invalid")
+
+	var appKey string
+	err := c.withRetry(ctx, func() error {
+		var createErr error
+		appKey, createErr = c.createAppKeyRPC(ctx, appName)
+		return createErr
+	})
+	return appKey, err
 }
 
-// refreshToken attempts to refresh the session token by restarting the anytype server
-func (c *AnyTypeClient) refreshToken(ctx context.Context) error {
-	c.refreshMutex.Lock()
-	defer c.refreshMutex.Unlock()
+// readKeyFile reads a key from a file, ignoring surrounding whitespace
+func readKeyFile(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("no key file configured")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
 
-	// Check if we recently refreshed (within last 30 seconds)
-	// This prevents rapid refresh loops
-	if time.Since(c.lastRefresh) < 30*time.Second {
-		fmt.Printf("[%s] Token refresh attempted too soon, skipping\n", time.Now().Format(time.RFC3339))
-		return fmt.Errorf("token refresh rate limit")
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("%s is empty", path)
 	}
+	return key, nil
+}
+
+// restartServerForToken restarts the anytype server so it writes a new session token.
+// Every other client of the server is disconnected, so this is opt-in only.
+// Only called from a token renewal, so restarts never overlap.
+func (c *AnyTypeClient) restartServerForToken() (string, error) {
+	authLog.Warn("Falling back to restarting the anytype server")
 
-	fmt.Printf("[%s] 🔄 Attempting to refresh session token...\n", time.Now().Format(time.RFC3339))
+	if c.supervisor != nil {
+		return c.restartSupervisedServer()
+	}
 
 	// Step 1: Kill existing anytype serve process
-	fmt.Printf("[%s]   → Stopping anytype server...\n", time.Now().Format(time.RFC3339))
+	authLog.Info("Stopping anytype server")
 	killCmd := exec.Command("pkill", "-f", "anytype serve")
 	if err := killCmd.Run(); err != nil {
 		// It's okay if pkill fails (process may not be running)
-		fmt.Printf("[%s]   ⚠ pkill returned: %v (may be normal)\n", time.Now().Format(time.RFC3339), err)
+		authLog.Debug("pkill failed; the server may not have been running", "error", err)
 	}
 
 	// Step 2: Wait for process to fully stop
 	time.Sleep(3 * time.Second)
 
 	// Step 3: Start anytype server in background
-	fmt.Printf("[%s]   → Starting anytype server...\n", time.Now().Format(time.RFC3339))
+	authLog.Info("Starting anytype server")
 	startCmd := exec.Command("nohup", c.anytypeBinary, "serve", "-q")
 
 	// Redirect output to log file
 	logFile, err := os.OpenFile("/tmp/anytype-serve.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
+		return "", fmt.Errorf("failed to open log file: %w", err)
 	}
 	defer logFile.Close()
 
@@ -139,53 +275,82 @@ func (c *AnyTypeClient) refreshToken(ctx context.Context) error {
 	startCmd.Stderr = logFile
 
 	if err := startCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start anytype server: %w", err)
+		return "", fmt.Errorf("failed to start anytype server: %w", err)
 	}
 
 	// Step 4: Wait for server to initialize and generate new token
-	fmt.Printf("[%s]   → Waiting for server to initialize...\n", time.Now().Format(time.RFC3339))
+	authLog.Info("Waiting for server to initialize")
 	time.Sleep(8 * time.Second)
 
 	// Step 5: Reload session token from config
-	fmt.Printf("[%s]   → Reading new session token...\n", time.Now().Format(time.RFC3339))
+	authLog.Debug("Reading new session token")
 	newToken, err := readSessionToken()
 	if err != nil {
-		return fmt.Errorf("failed to read new token: %w", err)
+		return "", fmt.Errorf("failed to read new token: %w", err)
 	}
 
-	// Step 6: Update client's session token
-	c.sessionToken = newToken
-	c.lastRefresh = time.Now()
+	authLog.Info("Session token renewed by restarting the server")
+	return newToken, nil
+}
 
-	fmt.Printf("[%s] ✓ Session token refreshed successfully\n", time.Now().Format(time.RFC3339))
-	return nil
+// restartSupervisedServer has the supervisor restart its server and picks up the
+// token the new server writes
+func (c *AnyTypeClient) restartSupervisedServer() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.supervisor.restartTimeout())
+	defer cancel()
+
+	if err := c.supervisor.Restart(ctx); err != nil {
+		return "", fmt.Errorf("failed to restart anytype server: %w", err)
+	}
+
+	newToken, err := readSessionToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to read new token: %w", err)
+	}
+
+	authLog.Info("Session token renewed by restarting the server")
+	return newToken, nil
 }
 
-// withRetry wraps an operation with automatic token refresh on auth errors
+// withRetry wraps an operation with recovery driven by the error kind: a token
+// refresh on auth errors, and reopening the space when it is not open
 func (c *AnyTypeClient) withRetry(ctx context.Context, operation func() error) error {
+	stale := c.tokens.peek()
 	err := operation()
-
-	// If no error or not an auth error, return immediately
-	if err == nil || !isAuthError(err) {
-		return err
+	if err == nil {
+		return nil
 	}
 
-	// Auth error detected - try to refresh token
-	fmt.Printf("[%s] ⚠ Authentication error detected: %v\n", time.Now().Format(time.RFC3339), err)
+	switch {
+	case isAuthError(err):
+		// Auth error detected - try to refresh token
+		clientLog.Warn("Authentication error, renewing token", "error", err)
 
-	if refreshErr := c.refreshToken(ctx); refreshErr != nil {
-		fmt.Printf("[%s] ✗ Token refresh failed: %v\n", time.Now().Format(time.RFC3339), refreshErr)
-		return fmt.Errorf("auth error (token refresh failed): %w", err)
-	}
+		if refreshErr := c.refreshToken(ctx, stale); refreshErr != nil {
+			clientLog.Error("Token renewal failed", "error", refreshErr)
+			return fmt.Errorf("auth error (token refresh failed): %w", err)
+		}
 
-	// Retry the operation with new token
-	fmt.Printf("[%s] 🔁 Retrying operation with refreshed token...\n", time.Now().Format(time.RFC3339))
-	err = operation()
+		// Retry the operation with new token
+		clientLog.Info("Retrying operation with renewed token")
 
-	if err != nil {
-		return fmt.Errorf("operation failed after token refresh: %w", err)
+	case errors.Is(err, ErrSpaceNotOpen) && c.openedSpace() != "":
+		clientLog.Warn("Space not open, reopening it", "space_id", c.openedSpace(), "error", err)
+
+		if _, openErr := c.openSpaceRPC(ctx, c.openedSpace()); openErr != nil {
+			clientLog.Error("Reopening space failed", "space_id", c.openedSpace(), "error", openErr)
+			return fmt.Errorf("space not open (reopen failed): %w", err)
+		}
+
+		clientLog.Info("Retrying operation after reopening space")
+
+	default:
+		return err
 	}
 
+	if err := operation(); err != nil {
+		return fmt.Errorf("operation failed after recovery: %w", err)
+	}
 	return nil
 }
 
@@ -195,7 +360,7 @@ func (c *AnyTypeClient) SyncMarkdown(ctx context.Context, change *FileChange, sp
 		return fmt.Errorf("gRPC client not connected")
 	}
 
-	fmt.Printf("[%s] gRPC: Syncing %s to space %s\n", time.Now().Format(time.RFC3339), change.Filename, spaceID)
+	clientLog.Debug("Syncing markdown", "path", change.Filename, "space_id", spaceID)
 
 	// Use the API layer to sync markdown to AnyType
 	_, err := c.SyncMarkdownToAnyType(ctx, change, spaceID)
@@ -208,7 +373,7 @@ func (c *AnyTypeClient) SyncMarkdownWithID(ctx context.Context, change *FileChan
 		return "", fmt.Errorf("gRPC client not connected")
 	}
 
-	fmt.Printf("[%s] gRPC: Syncing %s to space %s\n", time.Now().Format(time.RFC3339), change.Filename, spaceID)
+	clientLog.Debug("Syncing markdown", "path", change.Filename, "space_id", spaceID)
 
 	var objectID string
 	var syncErr error
@@ -226,13 +391,103 @@ func (c *AnyTypeClient) SyncMarkdownWithID(ctx context.Context, change *FileChan
 	return objectID, nil
 }
 
+// UpdateMarkdown updates the object previously created for a markdown file
+func (c *AnyTypeClient) UpdateMarkdown(ctx context.Context, objectID string, change *FileChange) error {
+	if c.conn == nil {
+		return fmt.Errorf("gRPC client not connected")
+	}
+
+	clientLog.Debug("Updating markdown", "path", change.Filename, "object_id", objectID)
+
+	// Wrap the update operation with automatic retry on auth errors
+	return c.withRetry(ctx, func() error {
+		return c.updateObject(ctx, objectID, change.Title, change.Content)
+	})
+}
+
+// UploadFile uploads a binary file (image, PDF, video, audio) and returns the object ID
+func (c *AnyTypeClient) UploadFile(ctx context.Context, filePath string, spaceID string) (string, error) {
+	if c.conn == nil {
+		return "", fmt.Errorf("gRPC client not connected")
+	}
+
+	var objectID string
+	err := c.withRetry(ctx, func() error {
+		var uploadErr error
+		objectID, uploadErr = c.uploadFile(ctx, filePath, spaceID)
+		return uploadErr
+	})
+	return objectID, err
+}
+
+// ArchiveObjects moves objects to the AnyType bin without deleting them
+func (c *AnyTypeClient) ArchiveObjects(ctx context.Context, objectIDs []string) error {
+	if c.conn == nil {
+		return fmt.Errorf("gRPC client not connected")
+	}
+
+	// Wrap the archive operation with automatic retry on auth errors
+	return c.withRetry(ctx, func() error {
+		return c.setArchived(ctx, objectIDs, true)
+	})
+}
+
+// UnarchiveObjects moves objects back out of the AnyType bin
+func (c *AnyTypeClient) UnarchiveObjects(ctx context.Context, objectIDs []string) error {
+	if c.conn == nil {
+		return fmt.Errorf("gRPC client not connected")
+	}
+
+	// Wrap the unarchive operation with automatic retry on auth errors
+	return c.withRetry(ctx, func() error {
+		return c.setArchived(ctx, objectIDs, false)
+	})
+}
+
+// CreateCollection creates an empty collection and returns its object ID
+func (c *AnyTypeClient) CreateCollection(ctx context.Context, name string, spaceID string) (string, error) {
+	if c.conn == nil {
+		return "", fmt.Errorf("gRPC client not connected")
+	}
+
+	var collectionID string
+	err := c.withRetry(ctx, func() error {
+		var createErr error
+		collectionID, createErr = c.createCollection(ctx, name, spaceID)
+		return createErr
+	})
+	return collectionID, err
+}
+
+// AddToCollection adds objects to a collection
+func (c *AnyTypeClient) AddToCollection(ctx context.Context, collectionID string, objectIDs []string) error {
+	if c.conn == nil {
+		return fmt.Errorf("gRPC client not connected")
+	}
+
+	return c.withRetry(ctx, func() error {
+		return c.collectionAdd(ctx, collectionID, objectIDs)
+	})
+}
+
+// RemoveFromCollection takes objects out of a collection
+func (c *AnyTypeClient) RemoveFromCollection(ctx context.Context, collectionID string, objectIDs []string) error {
+	if c.conn == nil {
+		return fmt.Errorf("gRPC client not connected")
+	}
+
+	return c.withRetry(ctx, func() error {
+		return c.collectionRemove(ctx, collectionID, objectIDs)
+	})
+}
+
 // DeleteMarkdown deletes a markdown file from AnyType
 func (c *AnyTypeClient) DeleteMarkdown(ctx context.Context, objectID string) error {
 	if c.conn == nil {
 		return fmt.Errorf("gRPC client not connected")
 	}
 
-	fmt.Printf("[%s] gRPC: Deleting object from AnyType\n", time.Now().Format(time.RFC3339))
+	clientLog.Debug("Deleting object", "object_id", objectID)
 
 	// Wrap the delete operation with automatic retry on auth errors
 	return c.withRetry(ctx, func() error {
@@ -240,8 +495,100 @@ func (c *AnyTypeClient) DeleteMarkdown(ctx context.Context, objectID string) err
 	})
 }
 
+// ListSyncedObjects returns every object in the space that was created from a file under rootDir
+func (c *AnyTypeClient) ListSyncedObjects(ctx context.Context, spaceID string, rootDir string) ([]RemoteObject, error) {
+	if c.conn == nil {
+		return nil, fmt.Errorf("gRPC client not connected")
+	}
+
+	filters := []*model.BlockContentDataviewFilter{
+		{
+			RelationKey: "sourceFilePath",
+			Condition:   model.BlockContentDataviewFilter_Like,
+			Value:       stringValue(rootDir),
+		},
+	}
+
+	var objects []RemoteObject
+	err := c.withRetry(ctx, func() error {
+		var searchErr error
+		objects, searchErr = c.searchObjects(ctx, spaceID, filters)
+		return searchErr
+	})
+	return objects, err
+}
+
+// FindObjectsByName returns the notes in the space whose name is one of names.
+// Pages, files and other object types are left out.
+func (c *AnyTypeClient) FindObjectsByName(ctx context.Context, spaceID string, names []string) ([]RemoteObject, error) {
+	if c.conn == nil {
+		return nil, fmt.Errorf("gRPC client not connected")
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	values := make([]*types.Value, len(names))
+	for i, name := range names {
+		values[i] = stringValue(name)
+	}
+	filters := []*model.BlockContentDataviewFilter{
+		{
+			RelationKey: "name",
+			Condition:   model.BlockContentDataviewFilter_In,
+			Value:       &types.Value{Kind: &types.Value_ListValue{ListValue: &types.ListValue{Values: values}}},
+		},
+		{
+			RelationKey: "layout",
+			Condition:   model.BlockContentDataviewFilter_Equal,
+			Value:       &types.Value{Kind: &types.Value_NumberValue{NumberValue: float64(model.ObjectType_note)}},
+		},
+	}
+
+	var objects []RemoteObject
+	err := c.withRetry(ctx, func() error {
+		var searchErr error
+		objects, searchErr = c.searchObjects(ctx, spaceID, filters)
+		return searchErr
+	})
+	return objects, err
+}
+
+// FindObjects returns the objects among objectIDs that still exist in the space
+func (c *AnyTypeClient) FindObjects(ctx context.Context, spaceID string, objectIDs []string) ([]RemoteObject, error) {
+	if c.conn == nil {
+		return nil, fmt.Errorf("gRPC client not connected")
+	}
+	if len(objectIDs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]*types.Value, len(objectIDs))
+	for i, id := range objectIDs {
+		ids[i] = stringValue(id)
+	}
+	filters := []*model.BlockContentDataviewFilter{
+		{
+			RelationKey: "id",
+			Condition:   model.BlockContentDataviewFilter_In,
+			Value:       &types.Value{Kind: &types.Value_ListValue{ListValue: &types.ListValue{Values: ids}}},
+		},
+	}
+
+	var objects []RemoteObject
+	err := c.withRetry(ctx, func() error {
+		var searchErr error
+		objects, searchErr = c.searchObjects(ctx, spaceID, filters)
+		return searchErr
+	})
+	return objects, err
+}
+
 // Close closes the gRPC connection
 func (c *AnyTypeClient) Close() error {
+	if closer, ok := c.tokens.provider.(io.Closer); ok {
+		closer.Close()
+	}
 	if c.conn != nil {
 		return c.conn.Close()
 	}
@@ -255,18 +602,63 @@ func (c *AnyTypeClient) OpenSpace(ctx context.Context, spaceID string) error {
 	}
 
 	// Wrap the open space operation with automatic retry on auth errors
-	return c.withRetry(ctx, func() error {
-		return c.openSpaceRPC(ctx, spaceID)
+	var profileID string
+	err := c.withRetry(ctx, func() error {
+		var openErr error
+		profileID, openErr = c.openSpaceRPC(ctx, spaceID)
+		return openErr
 	})
+	if err == nil {
+		c.spaceMu.Lock()
+		c.spaceID = spaceID
+		c.profileID = profileID
+		c.spaceMu.Unlock()
+	}
+	return err
 }
 
-// HealthCheck verifies connection to AnyType
+// openedSpace returns the space last opened with OpenSpace, so it can be reopened
+func (c *AnyTypeClient) openedSpace() string {
+	c.spaceMu.RLock()
+	defer c.spaceMu.RUnlock()
+
+	return c.spaceID
+}
+
+// HealthCheck verifies that the server answers, that the opened space is loaded and
+// that this account may write to it. It makes no recovery attempts of its own; the
+// returned error kind tells the health monitor what to repair.
 func (c *AnyTypeClient) HealthCheck(ctx context.Context) error {
 	if c.conn == nil {
-		return fmt.Errorf("not connected")
+		return fmt.Errorf("not connected: %w", ErrUnavailable)
+	}
+
+	// Cheapest call that needs a live server and a valid token
+	if _, err := c.appVersionRPC(ctx); err != nil {
+		return err
+	}
+
+	c.spaceMu.RLock()
+	spaceID, profileID := c.spaceID, c.profileID
+	c.spaceMu.RUnlock()
+
+	if spaceID == "" {
+		return fmt.Errorf("no space opened: %w", ErrSpaceNotOpen)
+	}
+	if profileID == "" {
+		// Older servers leave the profile out of the space info, so write access can't be checked
+		return nil
 	}
 
-	// TODO: Implement actual health check RPC call
-	fmt.Printf("[%s] gRPC: Health check placeholder\n", time.Now().Format(time.RFC3339))
+	perms, found, err := c.spacePermissionsRPC(ctx, spaceID, profileID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("space %s has no participant record for this account: %w", spaceID, ErrSpaceNotOpen)
+	}
+	if perms != model.ParticipantPermissions_Writer && perms != model.ParticipantPermissions_Owner {
+		return fmt.Errorf("space %s is not writable (%s): %w", spaceID, perms, ErrPermissionDenied)
+	}
 	return nil
 }
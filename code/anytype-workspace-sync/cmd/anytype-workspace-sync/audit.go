@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	anytypesync "github.com/robouden/anytype-workspace-sync"
+)
+
+// parseTime reads a flag time: RFC 3339, a date, or a duration meaning that long ago
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time: use RFC 3339, YYYY-MM-DD or a duration like 24h", value)
+}
+
+// runAudit implements the "audit" subcommand
+func runAudit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	path := fs.String("path", "", "file path, path in the workspace, file name, or a glob of one")
+	since := fs.String("since", "", "only entries after this time (RFC 3339, YYYY-MM-DD or a duration like 24h)")
+	until := fs.String("until", "", "only entries before this time")
+	outcome := fs.String("outcome", "", "only entries with this outcome: success, error, queued, held or skipped")
+	asJSON := fs.Bool("json", false, "print entries as JSON lines")
+	fs.Parse(args)
+
+	config, err := anytypesync.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	filter := anytypesync.AuditFilter{Path: *path, Outcome: *outcome}
+	if filter.Since, err = parseTime(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+
+	entries, err := anytypesync.ReadAudit(config, filter)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			encoder.Encode(entry)
+		}
+		return nil
+	}
+
+	for _, entry := range entries {
+		name := entry.Path
+		if rel, err := filepath.Rel(config.WorkspaceDir, entry.Path); err == nil && filepath.IsLocal(rel) {
+			name = rel
+		}
+		fmt.Printf("%s  %-6s  %-7s  %-9s  %s", entry.Time.Local().Format(time.RFC3339), entry.Op, entry.Outcome, entry.Trigger, name)
+		if entry.ObjectID != "" {
+			fmt.Printf(" -> %s", entry.ObjectID)
+		}
+		fmt.Printf(" (%s)", time.Duration(entry.Duration))
+		if entry.Detail != "" {
+			fmt.Printf(" %s", entry.Detail)
+		}
+		if entry.Error != "" {
+			fmt.Printf(": %s", entry.Error)
+		}
+		fmt.Println()
+	}
+	if len(entries) == 0 {
+		fmt.Println("No matching audit entries")
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	anytypesync "github.com/robouden/anytype-workspace-sync"
+)
+
+// runCommand dispatches maintenance subcommands; it returns false when args name no subcommand
+func runCommand(ctx context.Context, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case "reconcile":
+		err = runReconcile(ctx, args[1:])
+	case "dedupe":
+		err = runDedupe(ctx, args[1:])
+	case "deletions":
+		err = runDeletions(ctx, args[1:])
+	case "app-key":
+		err = runAppKey(ctx, args[1:])
+	case "status":
+		err = runStatus(ctx, args[1:])
+	case "audit":
+		err = runAudit(ctx, args[1:])
+	case "pause":
+		err = runPause(ctx, args[1:])
+	case "resume":
+		err = runResume(ctx, args[1:])
+	default:
+		return false
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// connectForCommand loads the sync state, connects to AnyType and opens the space.
+// Unlike the daemon, subcommands cannot do anything useful without a connection.
+// The service must not be running, as it holds the state database open.
+func connectForCommand(ctx context.Context) (*anytypesync.Engine, *anytypesync.AnyTypeClient, error) {
+	config, err := anytypesync.LoadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Keep log lines out of the report on stdout
+	if err := anytypesync.SetupLogging(config.Log, os.Stderr); err != nil {
+		return nil, nil, err
+	}
+
+	client, err := anytypesync.NewAnyTypeClient(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := client.OpenSpace(ctx, config.SpaceID); err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("failed to open space: %w", err)
+	}
+
+	engine, err := anytypesync.NewEngine(config, client, anytypesync.Hooks{})
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return engine, client, nil
+}
+
+// runAppKey implements the "app-key" subcommand: it registers this tool as an app
+// with the current session and stores the app key so the service can create its
+// own sessions later
+func runAppKey(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("app-key", flag.ExitOnError)
+	name := fs.String("name", "anytype-workspace-sync", "app name shown in AnyType")
+	fs.Parse(args)
+
+	config, err := anytypesync.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if config.Auth.AppKeyFile == "" {
+		return fmt.Errorf("auth.appKeyFile is not configured")
+	}
+
+	client, err := anytypesync.NewAnyTypeClient(config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	appKey, err := client.CreateAppKey(ctx, *name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(config.Auth.AppKeyFile, []byte(appKey+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write app key: %w", err)
+	}
+	fmt.Printf("App key for %q written to %s\n", *name, config.Auth.AppKeyFile)
+	return nil
+}
+
+// stdin is shared by every prompt, so answers piped in together are not lost
+// in the buffer of an earlier reader
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question on stdin and defaults to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, err := stdin.ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	anytypesync "github.com/robouden/anytype-workspace-sync"
+)
+
+// printDuplicates writes the dedupe preview
+func printDuplicates(groups []anytypesync.DuplicateGroup, hardDelete bool) {
+	action := "archive"
+	if hardDelete {
+		action = "delete"
+	}
+
+	extra := 0
+	for _, group := range groups {
+		extra += len(group.Extra)
+		fmt.Printf("%s (%d copies)\n", group.Key, len(group.Extra)+1)
+		fmt.Printf("  keep    %s %q created %s (%s)\n", group.Keep.ID, group.Keep.Name, anytypesync.FormatAge(group.Keep.Created), group.Reason)
+		for _, obj := range group.Extra {
+			fmt.Printf("  %-7s %s %q created %s\n", action, obj.ID, obj.Name, anytypesync.FormatAge(obj.Created))
+		}
+	}
+	fmt.Printf("%d duplicate groups, %d objects to %s\n", len(groups), extra, action)
+}
+
+// runDedupe implements the "dedupe" subcommand
+func runDedupe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only show the preview")
+	yes := fs.Bool("yes", false, "apply without asking")
+	hardDelete := fs.Bool("delete", false, "delete duplicates instead of archiving them")
+	fs.Parse(args)
+
+	engine, client, err := connectForCommand(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	defer engine.Close()
+
+	fmt.Printf("[%s] Looking for duplicate objects in space %s...\n", time.Now().Format(time.RFC3339), engine.Config().SpaceID)
+	groups, err := engine.FindDuplicates(ctx)
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		fmt.Println("No duplicates found")
+		return nil
+	}
+
+	printDuplicates(groups, *hardDelete)
+	if !*dryRun && !*yes && !confirm("Apply these changes?") {
+		*dryRun = true
+	}
+
+	actions, logPath, err := engine.Dedupe(ctx, groups, *hardDelete, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, a := range actions {
+		if a.Err != nil {
+			fmt.Printf("  ✗ %s %s (%s): %v\n", a.Action, a.Object.ID, a.Group, a.Err)
+		} else {
+			fmt.Printf("  ✓ %s %s (%s)\n", a.Action, a.Object.ID, a.Group)
+		}
+	}
+
+	fmt.Printf("Actions logged to %s\n", logPath)
+	return nil
+}
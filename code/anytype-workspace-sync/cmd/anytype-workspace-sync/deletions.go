@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	anytypesync "github.com/robouden/anytype-workspace-sync"
+)
+
+// runDeletions implements the "deletions" subcommand
+func runDeletions(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("deletions", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: anytype-workspace-sync deletions [list|approve|discard]")
+	}
+	fs.Parse(args)
+
+	action := "list"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+
+	config, err := anytypesync.LoadConfig()
+	if err != nil {
+		return err
+	}
+	// The service holds the state database open, so only the guard's files are read
+	guard, err := anytypesync.OpenDeleteGuard(config)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		reason, trippedAt, held := guard.Held()
+		if reason == "" {
+			fmt.Println("Deletions are flowing; nothing is held")
+			return nil
+		}
+		fmt.Printf("Deletions paused since %s: %s\n", trippedAt.Format(time.RFC3339), reason)
+		fmt.Printf("Held deletions (%d):\n", len(held))
+		for _, h := range held {
+			fmt.Printf("  %s -> %s (%s)\n", h.Path, h.ObjectID, h.HeldAt.Format(time.RFC3339))
+		}
+		return nil
+	case "approve", "discard":
+		if err := guard.Decide(action); err != nil {
+			return err
+		}
+		fmt.Printf("Decision %q recorded; the service applies it within %s\n", action, anytypesync.DecisionPollInterval)
+		return nil
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
@@ -0,0 +1,109 @@
+// Command anytype-workspace-sync mirrors a workspace directory into an AnyType
+// space. It is a thin wrapper around the anytypesync package: it loads the
+// config, optionally supervises "anytype serve", connects and runs the engine.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	anytypesync "github.com/robouden/anytype-workspace-sync"
+)
+
+// startSupervisor starts "anytype serve" as a child process and waits until it
+// listens. The server runs until serverCtx is cancelled; ctx only bounds the wait.
+func startSupervisor(ctx, serverCtx context.Context, config *anytypesync.Config) *anytypesync.Supervisor {
+	supervisor, err := anytypesync.NewSupervisor(config.Supervisor, config.Auth.AnytypeBinary, config.GRPCAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	go supervisor.Run(serverCtx)
+
+	slog.Info("Waiting for anytype server", "addr", config.GRPCAddr)
+	if err := supervisor.WaitReady(ctx); err != nil {
+		slog.Warn("anytype server is not ready", "error", err)
+	}
+	return supervisor
+}
+
+func main() {
+	// Maintenance subcommands run once and exit
+	if runCommand(context.Background(), os.Args[1:]) {
+		return
+	}
+
+	config, err := anytypesync.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if err := anytypesync.SetupLogging(config.Log, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	// SIGINT/SIGTERM stop the watcher; running operations then get
+	// shutdown.gracePeriod to finish before the service exits
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, config); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	slog.Info("Shutting down")
+}
+
+// run connects and runs the engine until ctx is cancelled. A supervised anytype
+// server is stopped only once the engine has finished its running operations.
+func run(ctx context.Context, config *anytypesync.Config) error {
+	// Run the anytype server ourselves when supervision is enabled
+	var supervisor *anytypesync.Supervisor
+	if config.Supervisor.Enabled {
+		serverCtx, stopServer := context.WithCancel(context.WithoutCancel(ctx))
+		supervisor = startSupervisor(ctx, serverCtx, config)
+		defer func() {
+			stopServer()
+			<-supervisor.Stopped()
+		}()
+	}
+
+	// Connect to AnyType gRPC. The connection is made in the background, so
+	// AnyType may come up after the service; changes are queued until it does.
+	var client anytypesync.Client
+	slog.Info("Connecting to AnyType", "addr", config.GRPCAddr)
+	rpc, err := anytypesync.NewAnyTypeClient(config)
+	if err != nil {
+		slog.Warn("Failed to set up the AnyType client; the watcher runs with sync disabled", "addr", config.GRPCAddr, "error", err)
+	} else {
+		defer rpc.Close()
+		client = rpc
+		if supervisor != nil {
+			rpc.SetSupervisor(supervisor)
+		}
+
+		// Open the space so we can create objects in it. If AnyType is not up
+		// yet, the health monitor opens it once the server answers.
+		slog.Info("Opening space", "space_id", config.SpaceID)
+		if err := rpc.OpenSpace(ctx, config.SpaceID); err != nil {
+			slog.Warn("Failed to open space; it is opened once AnyType is reachable", "space_id", config.SpaceID, "error", err)
+		}
+
+		// Health check
+		if err := rpc.HealthCheck(ctx); err != nil {
+			slog.Warn("Health check failed", "error", err)
+		}
+	}
+
+	engine, err := anytypesync.NewEngine(config, client, anytypesync.Hooks{})
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	return engine.Run(ctx)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	anytypesync "github.com/robouden/anytype-workspace-sync"
+)
+
+// controlCall sends a request to the control API of the running service and
+// decodes its JSON answer into out
+func controlCall(ctx context.Context, method, path string, out any) error {
+	config, err := anytypesync.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if !config.Control.Enabled {
+		return fmt.Errorf("the control API is disabled; set control.enabled in the config file")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	url := "http://" + config.Control.Addr + path
+	if config.Control.Socket != "" {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", config.Control.Socket)
+			},
+		}
+		url = "http://localhost" + path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	if config.Control.Socket == "" {
+		token, err := anytypesync.ReadControlToken(config)
+		if err != nil {
+			return fmt.Errorf("cannot authenticate to the service (is it running?): %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach the service (is it running?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, path, body)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// runPause implements the "pause" subcommand
+func runPause(ctx context.Context, args []string) error {
+	var result struct{}
+	if err := controlCall(ctx, http.MethodPost, "/pause", &result); err != nil {
+		return err
+	}
+	fmt.Println("Sync paused; changes are queued until \"resume\"")
+	return nil
+}
+
+// runResume implements the "resume" subcommand
+func runResume(ctx context.Context, args []string) error {
+	var result struct {
+		LastPause *anytypesync.PauseSummary `json:"lastPause"`
+	}
+	if err := controlCall(ctx, http.MethodPost, "/resume", &result); err != nil {
+		return err
+	}
+	fmt.Println("Sync resumed")
+	if result.LastPause != nil {
+		fmt.Printf("While %s\n", result.LastPause)
+	}
+	return nil
+}
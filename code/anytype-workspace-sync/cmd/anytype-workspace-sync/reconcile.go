@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	anytypesync "github.com/robouden/anytype-workspace-sync"
+)
+
+// printReport writes a human-readable summary of a reconcile report
+func printReport(report *anytypesync.ReconcileReport) {
+	fmt.Printf("Dangling mappings (%d):\n", len(report.Dangling))
+	for _, m := range report.Dangling {
+		fmt.Printf("  %s -> %s\n", m.Key, m.ObjectID)
+	}
+
+	fmt.Printf("Orphaned objects (%d):\n", len(report.Orphans))
+	for _, obj := range report.Orphans {
+		fmt.Printf("  %s %q from %s (created %s)\n", obj.ID, obj.Name, obj.SourcePath, anytypesync.FormatAge(obj.Created))
+	}
+
+	fmt.Printf("Files with no object (%d):\n", len(report.Missing))
+	for _, path := range report.Missing {
+		fmt.Printf("  %s\n", path)
+	}
+}
+
+// printRepairs writes the outcome of each repair
+func printRepairs(results []anytypesync.RepairResult) {
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf("  ✗ %s: not %s %s: %v\n", r.Key, r.Action, r.ObjectID, r.Err)
+			continue
+		}
+		fmt.Printf("  ✓ %s: %s %s\n", r.Key, r.Action, r.ObjectID)
+	}
+}
+
+// runReconcile implements the "reconcile" subcommand
+func runReconcile(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report, never repair")
+	yes := fs.Bool("yes", false, "repair every category without asking")
+	fs.Parse(args)
+
+	engine, client, err := connectForCommand(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	defer engine.Close()
+
+	fmt.Printf("[%s] Reconciling object map against space %s...\n", time.Now().Format(time.RFC3339), engine.Config().SpaceID)
+	report, err := engine.Reconcile(ctx)
+	if err != nil {
+		return err
+	}
+
+	printReport(report)
+	if report.Empty() {
+		fmt.Println("Object map, space and workspace are in sync")
+		return nil
+	}
+	if *dryRun {
+		return nil
+	}
+
+	if len(report.Dangling) > 0 && (*yes || confirm(fmt.Sprintf("Drop %d dangling mappings?", len(report.Dangling)))) {
+		printRepairs(engine.RepairDangling(report))
+	}
+	if len(report.Orphans) > 0 && (*yes || confirm(fmt.Sprintf("Adopt or remove %d orphaned objects?", len(report.Orphans)))) {
+		printRepairs(engine.RepairOrphans(ctx, report))
+	}
+	if len(report.Missing) > 0 && (*yes || confirm(fmt.Sprintf("Sync %d files with no object?", len(report.Missing)))) {
+		printRepairs(engine.RepairMissing(ctx, report))
+	}
+
+	return nil
+}
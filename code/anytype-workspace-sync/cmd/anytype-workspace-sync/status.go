@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	anytypesync "github.com/robouden/anytype-workspace-sync"
+)
+
+// runStatus implements the "status" subcommand
+func runStatus(ctx context.Context, args []string) error {
+	config, err := anytypesync.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	status, err := anytypesync.ReadStatus(config)
+	if err != nil {
+		return err
+	}
+
+	stale := ""
+	if time.Since(status.UpdatedAt) > 2*anytypesync.StatusInterval {
+		stale = " (stale - service may not be running)"
+	}
+	fmt.Printf("Updated:    %s%s\n", status.UpdatedAt.Format(time.RFC3339), stale)
+	fmt.Printf("PID:        %d\n", status.PID)
+	fmt.Printf("Connected:  %t\n", status.Connected)
+	if status.Paused {
+		switch {
+		case status.PausedUntil != nil:
+			fmt.Printf("Sync:       paused for maintenance until %s, %d changes queued\n", status.PausedUntil.Format(time.RFC3339), status.PausedChanges)
+		case status.PausedSince != nil:
+			fmt.Printf("Sync:       paused %s, %d changes queued\n", anytypesync.FormatAge(*status.PausedSince), status.PausedChanges)
+		default:
+			fmt.Printf("Sync:       paused, changes are queued\n")
+		}
+	} else if status.LastPause != nil {
+		fmt.Printf("Last pause: %s, %s\n", status.LastPause.Since.Format(time.RFC3339), status.LastPause)
+	}
+	if status.HealthError != "" {
+		fmt.Printf("Health:     %s, changed %s (%s)\n", status.Health, anytypesync.FormatAge(status.HealthSince), status.HealthError)
+	} else {
+		fmt.Printf("Health:     %s, changed %s\n", status.Health, anytypesync.FormatAge(status.HealthSince))
+	}
+	if status.Supervised {
+		fmt.Printf("Server:     supervised, pid %d, %d restarts\n", status.ServerPID, status.ServerRestarts)
+	}
+	fmt.Printf("Space:      %s\n", status.SpaceID)
+	fmt.Printf("Mappings:   %d\n", status.Mappings)
+	fmt.Printf("Breaker:    %s (%.0f%% failures)\n", status.Breaker, 100*status.FailureRate)
+	fmt.Printf("Queue:      %d pending\n", status.QueueDepth)
+	if status.TokenExpiry != nil {
+		fmt.Printf("Token:      expires %s (%s)\n", status.TokenExpiry.Format(time.RFC3339), anytypesync.FormatRemaining(*status.TokenExpiry))
+	} else {
+		fmt.Printf("Token:      no expiry known\n")
+	}
+	if status.DeletionsPaused != "" {
+		fmt.Printf("Deletions:  paused, %d held (%s)\n", status.HeldDeletions, status.DeletionsPaused)
+	} else {
+		fmt.Printf("Deletions:  flowing\n")
+	}
+	return nil
+}
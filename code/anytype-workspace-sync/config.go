@@ -0,0 +1,507 @@
+package anytypesync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultConfigFile = "/root/.anytype-workspace-sync.json"
+
+// Config holds the tunable behaviour of the sync service.
+// Every field is optional in the config file; missing fields keep their defaults.
+type Config struct {
+	// WorkspaceDir is the directory mirrored into AnyType
+	WorkspaceDir string `json:"workspaceDir"`
+	// SpaceID is the AnyType space objects are created in
+	SpaceID string `json:"spaceId"`
+	// GRPCAddr is where "anytype serve" listens, as host:port; it may be a remote host
+	GRPCAddr string `json:"grpcAddr"`
+	// StateDir holds the object map, tombstones, queue and other state files
+	StateDir string `json:"stateDir"`
+
+	Auth        AuthConfig        `json:"auth"`
+	TLS         TLSConfig         `json:"tls"`
+	Keepalive   KeepaliveConfig   `json:"keepalive"`
+	Delete      DeleteConfig      `json:"delete"`
+	DeleteGuard DeleteGuardConfig `json:"deleteGuard"`
+	Retry       RetryConfig       `json:"retry"`
+	Breaker     BreakerConfig     `json:"breaker"`
+	Health      HealthConfig      `json:"health"`
+	Supervisor  SupervisorConfig  `json:"supervisor"`
+	Log         LogConfig         `json:"log"`
+	Metrics     MetricsConfig     `json:"metrics"`
+	Control     ControlConfig     `json:"control"`
+	Shutdown    ShutdownConfig    `json:"shutdown"`
+	Audit       AuditConfig       `json:"audit"`
+	Maintenance MaintenanceConfig `json:"maintenance"`
+}
+
+// AuthConfig controls how the client obtains a new session token
+type AuthConfig struct {
+	// TokenFile holds the initial session token. $ANYTYPE_SYNC_TOKEN takes precedence,
+	// and without either the token is read from ~/.anytype/config.json.
+	TokenFile string `json:"tokenFile"`
+	// AppKeyFile holds an app key created with the "app-key" subcommand; tried first
+	AppKeyFile string `json:"appKeyFile"`
+	// AccountKeyFile holds the account key; tried when no app key is available
+	AccountKeyFile string `json:"accountKeyFile"`
+	// RestartServer allows restarting "anytype serve" as a last resort when no
+	// session can be created. This kills every other client of that server.
+	RestartServer bool `json:"restartServer"`
+	// AnytypeBinary is the anytype CLI used for RestartServer and by the supervisor
+	AnytypeBinary string `json:"anytypeBinary"`
+	// RenewBefore is how long before the token's exp claim it is renewed
+	RenewBefore Duration `json:"renewBefore"`
+}
+
+// TLSConfig secures the connection to a remote AnyType server
+type TLSConfig struct {
+	// Enabled dials with TLS instead of plaintext
+	Enabled bool `json:"enabled"`
+	// CAFile is a PEM bundle of CAs trusted for the server certificate; empty uses the system roots
+	CAFile string `json:"caFile"`
+	// CertFile and KeyFile are a PEM client certificate and key for mutual TLS
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// ServerName overrides the host name checked against the server certificate
+	ServerName string `json:"serverName"`
+}
+
+// KeepaliveConfig controls gRPC keepalive pings, which detect dead connections to
+// a remote server that would otherwise only fail on the next call
+type KeepaliveConfig struct {
+	// Time is how long the connection may be idle before a ping; 0 disables pings.
+	// gRPC servers reject pings more often than every 5 minutes by default.
+	Time Duration `json:"time"`
+	// Timeout is how long to wait for a ping reply before closing the connection
+	Timeout Duration `json:"timeout"`
+	// PermitWithoutStream also pings while no call or event stream is active
+	PermitWithoutStream bool `json:"permitWithoutStream"`
+}
+
+// DeleteConfig controls what happens to an object when its file disappears
+type DeleteConfig struct {
+	// Policy is one of "archive", "collection" or "delete"
+	Policy string `json:"policy"`
+	// Retention is how long a removed object is kept before it is hard-deleted; 0 keeps it forever
+	// (or, with the "delete" policy, deletes it immediately)
+	Retention Duration `json:"retention"`
+	// SweepInterval is how often the sweeper looks for expired objects
+	SweepInterval Duration `json:"sweepInterval"`
+	// CollectionName is the collection removed objects are moved to with the "collection" policy
+	CollectionName string `json:"collectionName"`
+}
+
+// DeleteGuardConfig controls the mass-deletion circuit breaker. Deletions are held
+// for operator confirmation once either threshold is crossed within Window.
+type DeleteGuardConfig struct {
+	// MaxDeletes is the number of deletions allowed per window; 0 disables the check
+	MaxDeletes int `json:"maxDeletes"`
+	// MaxPercent is the share of mapped files that may be deleted per window; 0 disables the check
+	MaxPercent float64 `json:"maxPercent"`
+	// MinDeletes is how many deletions per window pass before MaxPercent applies,
+	// so one deletion in a small workspace does not trip the guard
+	MinDeletes int `json:"minDeletes"`
+	// Window is the sliding window both thresholds are measured over
+	Window Duration `json:"window"`
+}
+
+// RetryConfig holds a retry policy per operation class
+type RetryConfig struct {
+	Default RetryPolicy `json:"default"`
+	Create  RetryPolicy `json:"create"`
+	Update  RetryPolicy `json:"update"`
+	Upload  RetryPolicy `json:"upload"`
+	Delete  RetryPolicy `json:"delete"`
+}
+
+// RetryPolicy controls how one class of AnyType calls is retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int `json:"maxAttempts"`
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff Duration `json:"initialBackoff"`
+	// MaxBackoff caps the delay between retries
+	MaxBackoff Duration `json:"maxBackoff"`
+	// Multiplier grows the delay after each retry
+	Multiplier float64 `json:"multiplier"`
+	// Jitter randomises each delay by up to this fraction (0-1)
+	Jitter float64 `json:"jitter"`
+	// AttemptTimeout is the deadline for a single attempt; 0 means none
+	AttemptTimeout Duration `json:"attemptTimeout"`
+	// RetryOnTimeout retries attempts that hit AttemptTimeout. Not allowed for
+	// creates, since a timed-out create may still have created the object.
+	RetryOnTimeout bool `json:"retryOnTimeout"`
+}
+
+// defaultRetryPolicy is the starting point for every operation class
+func defaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: Duration(500 * time.Millisecond),
+		MaxBackoff:     Duration(10 * time.Second),
+		Multiplier:     2,
+		Jitter:         0.2,
+		AttemptTimeout: Duration(15 * time.Second),
+		RetryOnTimeout: true,
+	}
+}
+
+// BreakerConfig controls the circuit breaker around the AnyType client
+type BreakerConfig struct {
+	// WindowSize is how many recent calls the failure rate is measured over
+	WindowSize int `json:"windowSize"`
+	// MinRequests is how many calls the window needs before the breaker can open
+	MinRequests int `json:"minRequests"`
+	// FailureRate is the share of failed calls (0-1) that opens the breaker
+	FailureRate float64 `json:"failureRate"`
+	// OpenDuration is how long the breaker stays open before probing
+	OpenDuration Duration `json:"openDuration"`
+	// HalfOpenProbes is how many probe calls may run at once while half-open
+	HalfOpenProbes int `json:"halfOpenProbes"`
+}
+
+// HealthConfig controls the periodic liveness check
+type HealthConfig struct {
+	// Interval is how often the health check runs
+	Interval Duration `json:"interval"`
+	// Timeout bounds a single health check, including its retries
+	Timeout Duration `json:"timeout"`
+	// DownAfter is how many consecutive unreachable checks mark AnyType as down
+	DownAfter int `json:"downAfter"`
+}
+
+// SupervisorConfig controls running "anytype serve" as a child of the sync service
+type SupervisorConfig struct {
+	// Enabled makes the service start, restart and stop the server itself
+	Enabled bool `json:"enabled"`
+	// Args are passed to auth.anytypeBinary
+	Args []string `json:"args"`
+	// LogFile receives the server's output
+	LogFile string `json:"logFile"`
+	// LogMaxSizeMB is the size at which LogFile is rotated
+	LogMaxSizeMB int `json:"logMaxSizeMB"`
+	// LogBackups is how many rotated log files are kept
+	LogBackups int `json:"logBackups"`
+	// ReadyTimeout is how long to wait for the gRPC port after a start
+	ReadyTimeout Duration `json:"readyTimeout"`
+	// InitialBackoff and MaxBackoff bound the delay before restarting a crashed server
+	InitialBackoff Duration `json:"initialBackoff"`
+	MaxBackoff     Duration `json:"maxBackoff"`
+	// StopTimeout is how long the server gets to exit after SIGTERM before it is killed
+	StopTimeout Duration `json:"stopTimeout"`
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint
+type MetricsConfig struct {
+	// Enabled serves metrics over HTTP
+	Enabled bool `json:"enabled"`
+	// Addr is the host:port to listen on; keep it on localhost unless scrapers need it
+	Addr string `json:"addr"`
+	// Path is the URL path metrics are served on
+	Path string `json:"path"`
+}
+
+// ControlConfig controls the local HTTP API for checking and steering the running service
+type ControlConfig struct {
+	// Enabled serves the control API
+	Enabled bool `json:"enabled"`
+	// Addr is the host:port to listen on when no socket is set; keep it on localhost
+	Addr string `json:"addr"`
+	// Socket is a unix socket to listen on instead of Addr
+	Socket string `json:"socket"`
+}
+
+// ShutdownConfig controls how the service stops on SIGINT or SIGTERM
+type ShutdownConfig struct {
+	// GracePeriod is how long running uploads and deletions get to finish before
+	// they are aborted and queued for the next start
+	GracePeriod Duration `json:"gracePeriod"`
+}
+
+// AuditConfig controls the log of every sync decision kept under StateDir
+type AuditConfig struct {
+	// Enabled records sync decisions
+	Enabled bool `json:"enabled"`
+	// MaxSizeMB rotates the log once it grows past this size; 0 never rotates
+	MaxSizeMB int `json:"maxSizeMB"`
+	// Backups is how many rotated logs are kept
+	Backups int `json:"backups"`
+}
+
+// MaintenanceConfig schedules windows during which syncing is paused
+type MaintenanceConfig struct {
+	// Windows are the periods syncing is paused for
+	Windows []MaintenanceWindow `json:"windows"`
+	// Timezone is the IANA zone window times are in; empty means the local zone
+	Timezone string `json:"timezone"`
+}
+
+// MaintenanceWindow pauses syncing from Start for Duration: on Date if it is set,
+// otherwise on each of Days, or every day when both are empty
+type MaintenanceWindow struct {
+	// Start is the time of day the window opens, HH:MM or HH:MM:SS
+	Start string `json:"start"`
+	// Duration is how long it stays open, at most 24h
+	Duration Duration `json:"duration"`
+	// Days are the weekdays it opens on: sun, mon, tue, wed, thu, fri or sat
+	Days []string `json:"days,omitempty"`
+	// Date is the single day, YYYY-MM-DD, a one-off window opens on
+	Date string `json:"date,omitempty"`
+}
+
+// Delete policies
+const (
+	DeletePolicyArchive    = "archive"
+	DeletePolicyCollection = "collection"
+	DeletePolicyDelete     = "delete"
+)
+
+// DefaultConfig returns the configuration used when no config file exists
+func DefaultConfig() *Config {
+	return &Config{
+		WorkspaceDir: "/root/anytype-workspace",
+		SpaceID:      "bafyreig4q7t3vt7b7zmvfv3emj7jfrvjamuhu4crws3dhn3uaxhh3u37k4.10piockh34xft",
+		GRPCAddr:     "127.0.0.1:31010",
+		StateDir:     "/root",
+		Auth: AuthConfig{
+			AppKeyFile:    "/root/.anytype-workspace-sync.appkey",
+			AnytypeBinary: "/root/.local/bin/anytype",
+			RenewBefore:   Duration(5 * time.Minute),
+		},
+		Keepalive: KeepaliveConfig{
+			Timeout: Duration(20 * time.Second),
+		},
+		Delete: DeleteConfig{
+			Policy:         DeletePolicyArchive,
+			Retention:      Duration(30 * 24 * time.Hour),
+			SweepInterval:  Duration(time.Hour),
+			CollectionName: "Deleted from disk",
+		},
+		DeleteGuard: DeleteGuardConfig{
+			MaxDeletes: 20,
+			MaxPercent: 25,
+			MinDeletes: 5,
+			Window:     Duration(time.Minute),
+		},
+		Retry: defaultRetryConfig(),
+		Breaker: BreakerConfig{
+			WindowSize:     20,
+			MinRequests:    5,
+			FailureRate:    0.5,
+			OpenDuration:   Duration(30 * time.Second),
+			HalfOpenProbes: 1,
+		},
+		Health: HealthConfig{
+			Interval:  Duration(30 * time.Second),
+			Timeout:   Duration(10 * time.Second),
+			DownAfter: 3,
+		},
+		Supervisor: SupervisorConfig{
+			Args:           []string{"serve", "-q"},
+			LogFile:        "/root/.anytype-serve.log",
+			LogMaxSizeMB:   10,
+			LogBackups:     3,
+			ReadyTimeout:   Duration(time.Minute),
+			InitialBackoff: Duration(time.Second),
+			MaxBackoff:     Duration(time.Minute),
+			StopTimeout:    Duration(10 * time.Second),
+		},
+		Log: LogConfig{
+			Format: LogFormatText,
+			Level:  "info",
+		},
+		Metrics: MetricsConfig{
+			Addr: "127.0.0.1:9464",
+			Path: "/metrics",
+		},
+		Control: ControlConfig{
+			Addr: "127.0.0.1:9465",
+		},
+		Shutdown: ShutdownConfig{
+			GracePeriod: Duration(20 * time.Second),
+		},
+		Audit: AuditConfig{
+			Enabled:   true,
+			MaxSizeMB: 50,
+			Backups:   5,
+		},
+	}
+}
+
+// defaultRetryConfig returns the default retry policies
+func defaultRetryConfig() RetryConfig {
+	create := defaultRetryPolicy()
+	create.RetryOnTimeout = false
+
+	upload := defaultRetryPolicy()
+	upload.AttemptTimeout = Duration(2 * time.Minute)
+
+	return RetryConfig{
+		Default: defaultRetryPolicy(),
+		Create:  create,
+		Update:  defaultRetryPolicy(),
+		Upload:  upload,
+		Delete:  defaultRetryPolicy(),
+	}
+}
+
+// LoadConfig reads the config file named by $ANYTYPE_SYNC_CONFIG, or the default path.
+// A missing file is not an error.
+func LoadConfig() (*Config, error) {
+	path := os.Getenv("ANYTYPE_SYNC_CONFIG")
+	if path == "" {
+		path = defaultConfigFile
+	}
+
+	config := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// validate rejects settings the service cannot act on
+func (c *Config) validate() error {
+	if c.WorkspaceDir == "" || c.SpaceID == "" || c.GRPCAddr == "" || c.StateDir == "" {
+		return fmt.Errorf("workspaceDir, spaceId, grpcAddr and stateDir must be set")
+	}
+	if c.Auth.RenewBefore < 0 {
+		return fmt.Errorf("auth.renewBefore must not be negative")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls.certFile and tls.keyFile must be set together")
+	}
+	if !c.TLS.Enabled && (c.TLS.CAFile != "" || c.TLS.CertFile != "" || c.TLS.ServerName != "") {
+		return fmt.Errorf("tls settings are given but tls.enabled is false")
+	}
+	if c.Keepalive.Time < 0 || c.Keepalive.Timeout <= 0 {
+		return fmt.Errorf("keepalive.time must not be negative and keepalive.timeout must be positive")
+	}
+	switch c.Delete.Policy {
+	case DeletePolicyArchive, DeletePolicyCollection, DeletePolicyDelete:
+	default:
+		return fmt.Errorf("unknown delete policy %q", c.Delete.Policy)
+	}
+	if c.Delete.SweepInterval <= 0 {
+		return fmt.Errorf("delete.sweepInterval must be positive")
+	}
+	if c.DeleteGuard.MaxDeletes < 0 || c.DeleteGuard.MinDeletes < 0 {
+		return fmt.Errorf("deleteGuard.maxDeletes and minDeletes must not be negative")
+	}
+	if c.DeleteGuard.MaxPercent < 0 || c.DeleteGuard.MaxPercent > 100 {
+		return fmt.Errorf("deleteGuard.maxPercent must be between 0 and 100")
+	}
+	if c.DeleteGuard.Window <= 0 {
+		return fmt.Errorf("deleteGuard.window must be positive")
+	}
+
+	if c.Breaker.WindowSize < 1 || c.Breaker.MinRequests < 1 || c.Breaker.HalfOpenProbes < 1 {
+		return fmt.Errorf("breaker.windowSize, minRequests and halfOpenProbes must be at least 1")
+	}
+	if c.Breaker.FailureRate <= 0 || c.Breaker.FailureRate > 1 {
+		return fmt.Errorf("breaker.failureRate must be between 0 and 1")
+	}
+	if c.Health.Interval <= 0 || c.Health.Timeout <= 0 {
+		return fmt.Errorf("health.interval and health.timeout must be positive")
+	}
+	if c.Health.DownAfter < 1 {
+		return fmt.Errorf("health.downAfter must be at least 1")
+	}
+	if c.Supervisor.Enabled {
+		if c.Supervisor.LogMaxSizeMB < 0 || c.Supervisor.LogBackups < 0 {
+			return fmt.Errorf("supervisor.logMaxSizeMB and logBackups must not be negative")
+		}
+		if c.Supervisor.ReadyTimeout <= 0 || c.Supervisor.StopTimeout <= 0 {
+			return fmt.Errorf("supervisor.readyTimeout and stopTimeout must be positive")
+		}
+		if c.Supervisor.InitialBackoff <= 0 || c.Supervisor.MaxBackoff < c.Supervisor.InitialBackoff {
+			return fmt.Errorf("supervisor.initialBackoff must be positive and no larger than maxBackoff")
+		}
+	}
+
+	if err := c.Log.validate(); err != nil {
+		return err
+	}
+	if c.Metrics.Enabled && (c.Metrics.Addr == "" || !strings.HasPrefix(c.Metrics.Path, "/")) {
+		return fmt.Errorf("metrics.addr must be set and metrics.path must start with /")
+	}
+	if c.Control.Enabled && c.Control.Addr == "" && c.Control.Socket == "" {
+		return fmt.Errorf("control.addr or control.socket must be set")
+	}
+	if c.Shutdown.GracePeriod <= 0 {
+		return fmt.Errorf("shutdown.gracePeriod must be positive")
+	}
+	if c.Audit.MaxSizeMB < 0 || c.Audit.Backups < 0 {
+		return fmt.Errorf("audit.maxSizeMB and audit.backups must not be negative")
+	}
+	if err := c.Maintenance.validate(); err != nil {
+		return err
+	}
+
+	policies := map[string]RetryPolicy{
+		opDefault: c.Retry.Default,
+		opCreate:  c.Retry.Create,
+		opUpdate:  c.Retry.Update,
+		opUpload:  c.Retry.Upload,
+		opDelete:  c.Retry.Delete,
+	}
+	for class, p := range policies {
+		if p.MaxAttempts < 1 {
+			return fmt.Errorf("retry.%s.maxAttempts must be at least 1", class)
+		}
+		if p.Multiplier < 1 {
+			return fmt.Errorf("retry.%s.multiplier must be at least 1", class)
+		}
+		if p.Jitter < 0 || p.Jitter > 1 {
+			return fmt.Errorf("retry.%s.jitter must be between 0 and 1", class)
+		}
+		if p.RetryOnTimeout && nonIdempotent[class] {
+			return fmt.Errorf("retry.%s.retryOnTimeout must be false; these calls are not safe to resend", class)
+		}
+	}
+	return nil
+}
+
+// statePath returns the path of a state file under StateDir
+func (c *Config) statePath(name string) string {
+	return filepath.Join(c.StateDir, name)
+}
+
+// Duration is a time.Duration that reads and writes as a string such as "720h"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"24h\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON formats a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
@@ -0,0 +1,362 @@
+package anytypesync
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// controlHeartbeat is how often an idle event stream gets a comment line, so
+	// proxies and clients don't time it out
+	controlHeartbeat = 15 * time.Second
+
+	// controlTokenFile holds the bearer token the control API requires over TCP,
+	// under the state directory
+	controlTokenFile = ".anytype-workspace-control-token"
+)
+
+// errFullSyncRunning is returned when a full sync is asked for while one runs
+var errFullSyncRunning = errors.New("a full sync is already running")
+
+// controlServer serves the local control API of a running engine
+type controlServer struct {
+	engine *Engine
+	ctx    context.Context // the engine's run context; outlives requests
+}
+
+// serveControl serves the control API until ctx is cancelled. A failure to listen
+// is logged; syncing goes on without the API.
+func (e *Engine) serveControl(ctx context.Context) {
+	listener, err := listenControl(e.config.Control)
+	if err != nil {
+		engineLog.Error("Control API failed to listen", "error", err)
+		return
+	}
+
+	// Any local process, or a web page through the browser, can reach a TCP port,
+	// so it takes a token only the service's user can read
+	handler := e.ControlHandler(ctx)
+	if e.config.Control.Socket == "" {
+		token, err := createControlToken(e.config.statePath(controlTokenFile))
+		if err != nil {
+			listener.Close()
+			engineLog.Error("Control API failed to create its token", "error", err)
+			return
+		}
+		handler = requireToken(token, handler)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	engineLog.Info("Serving control API", "addr", listener.Addr().String())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		engineLog.Error("Control API failed", "error", err)
+	}
+}
+
+// listenControl listens on the unix socket when one is configured, else on the TCP address
+func listenControl(config ControlConfig) (net.Listener, error) {
+	if config.Socket == "" {
+		return net.Listen("tcp", config.Addr)
+	}
+
+	// A socket left by a previous run would make the rename below fail
+	if err := os.Remove(config.Socket); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	// Anyone who can connect can pause syncing, so keep it to this user. The
+	// socket is made in a directory only this user can enter and restricted
+	// before it is moved into place, so nobody can connect in between.
+	dir, err := os.MkdirTemp(filepath.Dir(config.Socket), ".control-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket: %w", err)
+	}
+	if err := os.Rename(tmp, config.Socket); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to move socket into place: %w", err)
+	}
+	return socketListener{listener, config.Socket}, nil
+}
+
+// socketListener removes its unix socket when closed, from where it was moved to
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+// Close stops listening and removes the socket
+func (l socketListener) Close() error {
+	err := l.Listener.Close()
+	if removeErr := os.Remove(l.path); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// createControlToken returns the token kept at path, creating a random one on the
+// first start. Keeping it across restarts lets scripts read it once.
+func createControlToken(path string) (string, error) {
+	if data, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ReadControlToken returns the token the running service requires on its TCP
+// control API, for clients of the API
+func ReadControlToken(config *Config) (string, error) {
+	data, err := os.ReadFile(config.statePath(controlTokenFile))
+	if err != nil {
+		return "", fmt.Errorf("failed to read control token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// requireToken rejects requests without "Authorization: Bearer <token>". A
+// cross-origin request from a browser cannot set that header without a CORS
+// preflight, which the API does not answer.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong control token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ControlHandler returns the control API, for programs that embed the engine and
+// serve it themselves; it does no authentication of its own. ctx bounds work the
+// API starts in the background, such as a full resync.
+func (e *Engine) ControlHandler(ctx context.Context) http.Handler {
+	s := &controlServer{engine: e, ctx: ctx}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.status)
+	mux.HandleFunc("GET /queue", s.queue)
+	mux.HandleFunc("POST /resync", s.resync)
+	mux.HandleFunc("POST /pause", s.pause)
+	mux.HandleFunc("POST /resume", s.resume)
+	mux.HandleFunc("GET /map/{path...}", s.lookup)
+	mux.HandleFunc("GET /object/{id}", s.lookupObject)
+	mux.HandleFunc("GET /events", s.events)
+	return mux
+}
+
+// status returns the same snapshot as the status file
+func (s *controlServer) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Status())
+}
+
+// queue returns the pending operations, oldest first
+func (s *controlServer) queue(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Pending())
+}
+
+// resync syncs one file, given as {"path": ...} or ?path=, and waits for the
+// result; without a path it starts a sync of every file and returns at once, or
+// refuses while a full sync is already running
+func (s *controlServer) resync(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path string `json:"path"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	if req.Path == "" {
+		req.Path = r.URL.Query().Get("path")
+	}
+
+	if req.Path == "" {
+		if !s.engine.fullSync.CompareAndSwap(false, true) {
+			writeError(w, http.StatusConflict, errFullSyncRunning)
+			return
+		}
+		go func() {
+			defer s.engine.fullSync.Store(false)
+			s.engine.syncAll(WithTrigger(s.ctx, TriggerControl))
+		}()
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "resync started"})
+		return
+	}
+
+	path, err := s.engine.workspacePath(req.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if !isSupportedFile(path) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s is not a supported file type", req.Path))
+		return
+	}
+
+	objectID, err := s.engine.SyncFile(WithTrigger(r.Context(), TriggerControl), path)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, map[string]string{"path": path, "objectId": objectID})
+	case errors.Is(err, errPaused), errors.Is(err, errNotConnected), isTransient(err):
+		writeJSON(w, http.StatusAccepted, map[string]string{"path": path, "status": "queued", "reason": err.Error()})
+	default:
+		writeError(w, http.StatusBadGateway, err)
+	}
+}
+
+// pause stops sending changes to AnyType
+func (s *controlServer) pause(w http.ResponseWriter, r *http.Request) {
+	s.engine.Pause()
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+}
+
+// resume sends changes to AnyType again and returns what piled up while paused
+func (s *controlServer) resume(w http.ResponseWriter, r *http.Request) {
+	s.engine.Resume()
+	writeJSON(w, http.StatusOK, map[string]any{"paused": false, "lastPause": s.engine.LastPause()})
+}
+
+// lookup returns the sync state of a workspace file, including its object
+func (s *controlServer) lookup(w http.ResponseWriter, r *http.Request) {
+	path, err := s.engine.workspacePath(r.PathValue("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	state, ok := s.engine.objects.State(s.engine.objectKey(path))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no object mapped to %s", r.PathValue("path")))
+		return
+	}
+	// Mappings migrated from the JSON object map have no path until the next sync
+	if state.Path == "" {
+		state.Path = path
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// lookupObject returns the sync state of the file mapped to an object
+func (s *controlServer) lookupObject(w http.ResponseWriter, r *http.Request) {
+	state, ok := s.engine.objects.ByObjectID(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no file mapped to object %s", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// events streams engine activity as server-sent events until the client goes away
+func (s *controlServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	activity, unsubscribe := s.engine.SubscribeActivity(100)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(controlHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case a := <-activity:
+			data, err := json.Marshal(a)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", a.Kind, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// workspacePath resolves a path relative to the workspace, or an absolute one,
+// and rejects paths outside the workspace
+func (e *Engine) workspacePath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(e.config.WorkspaceDir, path)
+	}
+	path = filepath.Clean(path)
+
+	rel, err := filepath.Rel(e.config.WorkspaceDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the workspace", path)
+	}
+	return path, nil
+}
+
+// writeJSON sends v as the JSON response body
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends err as a JSON error response
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
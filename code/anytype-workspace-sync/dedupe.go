@@ -0,0 +1,238 @@
+package anytypesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anyproto/anytype-heart/pkg/lib/pb/model"
+)
+
+// dedupeLogFile is the dedupe action log file name under the state directory
+const dedupeLogFile = ".anytype-workspace-dedupe.log"
+
+// DuplicateGroup is a set of objects that all represent the same workspace file
+type DuplicateGroup struct {
+	Key    string         // object map key, or "title:<name>" when no file matches
+	Keep   RemoteObject   // the copy that survives
+	Extra  []RemoteObject // copies to archive or delete
+	Adopt  bool           // Keep is not mapped yet and should be recorded in the object map
+	Reason string         // why Keep was chosen
+}
+
+// DedupeAction is one action taken, or skipped in a dry run, by Dedupe
+type DedupeAction struct {
+	Action string // keep, adopt, archive, delete or skip
+	Group  string
+	Object RemoteObject
+	Err    error // why the action failed; nil when it was taken
+}
+
+// dedupeLogEntry is one line of the dedupe action log
+type dedupeLogEntry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"` // keep, adopt, archive, delete, skip
+	Group      string    `json:"group"`
+	ObjectID   string    `json:"objectId"`
+	Name       string    `json:"name"`
+	SourcePath string    `json:"sourcePath,omitempty"`
+	DryRun     bool      `json:"dryRun,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// FindDuplicates groups sync-created objects by source path, and by title for
+// notes created before the source path was recorded
+func (e *Engine) FindDuplicates(ctx context.Context) ([]DuplicateGroup, error) {
+	if e.client == nil {
+		return nil, errNotConnected
+	}
+	dir := e.config.WorkspaceDir
+	files, err := workspaceFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspace files: %w", err)
+	}
+
+	// Map titles back to object map keys so unmarked copies join their file's group
+	keyByTitle := make(map[string]string)
+	var titles []string
+	for _, file := range files {
+		key := e.objectKey(file)
+		title := legacyKey(file)
+		if strings.HasSuffix(file, ".md") {
+			if change, err := ParseMarkdown(file); err == nil {
+				title = change.Title
+			}
+		}
+		keyByTitle[title] = key
+		titles = append(titles, title)
+	}
+
+	synced, err := e.client.ListSyncedObjects(ctx, e.config.SpaceID, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list synced objects: %w", err)
+	}
+	named, err := e.client.FindObjectsByName(ctx, e.config.SpaceID, titles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search objects by title: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	groups := make(map[string][]RemoteObject)
+	for _, obj := range append(synced, named...) {
+		if seen[obj.ID] || e.tombstones.Has(obj.ID) {
+			continue
+		}
+		seen[obj.ID] = true
+
+		// Only notes this tool could have created are candidates: never another
+		// object type, and never a note synced from a different directory
+		if obj.Layout != model.ObjectType_note && obj.SourcePath == "" {
+			continue
+		}
+		if _, err := e.workspacePath(obj.SourcePath); obj.SourcePath != "" && err != nil {
+			continue
+		}
+
+		var key string
+		switch {
+		case obj.SourcePath != "":
+			key = e.objectKey(obj.SourcePath)
+		case keyByTitle[obj.Name] != "":
+			key = keyByTitle[obj.Name]
+		default:
+			key = "title:" + obj.Name
+		}
+		groups[key] = append(groups[key], obj)
+	}
+
+	entries := e.objects.Entries()
+	var duplicates []DuplicateGroup
+	for key, objects := range groups {
+		if len(objects) < 2 {
+			continue
+		}
+		sort.Slice(objects, func(i, j int) bool { return objects[i].Created.After(objects[j].Created) })
+
+		// Prefer the mapped copy, otherwise the newest
+		group := DuplicateGroup{Key: key, Keep: objects[0], Reason: "newest"}
+		for _, obj := range objects {
+			if entries[key] == obj.ID {
+				group.Keep = obj
+				group.Reason = "referenced by object map"
+				break
+			}
+		}
+		for _, obj := range objects {
+			if obj.ID != group.Keep.ID {
+				group.Extra = append(group.Extra, obj)
+			}
+		}
+		if _, mapped := entries[key]; !mapped && e.keyHasFile(key, files) {
+			group.Adopt = true
+		}
+		duplicates = append(duplicates, group)
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].Key < duplicates[j].Key })
+	return duplicates, nil
+}
+
+// keyHasFile reports whether any of files maps to key
+func (e *Engine) keyHasFile(key string, files []string) bool {
+	for _, file := range files {
+		if e.objectKey(file) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupeLog appends actions to the dedupe log so they can be reviewed later
+type dedupeLog struct {
+	file    *os.File
+	dryRun  bool
+	actions []DedupeAction // recorded so far
+}
+
+// openDedupeLog opens the dedupe log at path for appending
+func openDedupeLog(path string, dryRun bool) (*dedupeLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dedupe log: %w", err)
+	}
+	return &dedupeLog{file: file, dryRun: dryRun}, nil
+}
+
+// record writes one action to the log
+func (l *dedupeLog) record(action string, group string, obj RemoteObject, actionErr error) {
+	entry := dedupeLogEntry{
+		Time:       time.Now(),
+		Action:     action,
+		Group:      group,
+		ObjectID:   obj.ID,
+		Name:       obj.Name,
+		SourcePath: obj.SourcePath,
+		DryRun:     l.dryRun,
+	}
+	if actionErr != nil {
+		entry.Error = actionErr.Error()
+	}
+	l.actions = append(l.actions, DedupeAction{Action: action, Group: group, Object: obj, Err: actionErr})
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		engineLog.Warn("Failed to write dedupe log", "path", l.file.Name(), "error", err)
+	}
+}
+
+// Close closes the log file
+func (l *dedupeLog) Close() error {
+	return l.file.Close()
+}
+
+// Dedupe keeps one object of every group and archives the others, or deletes them
+// when hardDelete is set. With dryRun nothing is changed. Every action is written
+// to the dedupe log, whose path is returned along with the actions.
+func (e *Engine) Dedupe(ctx context.Context, groups []DuplicateGroup, hardDelete bool, dryRun bool) ([]DedupeAction, string, error) {
+	if e.client == nil {
+		return nil, "", errNotConnected
+	}
+
+	path := e.config.statePath(dedupeLogFile)
+	log, err := openDedupeLog(path, dryRun)
+	if err != nil {
+		return nil, "", err
+	}
+	defer log.Close()
+
+	for _, group := range groups {
+		log.record("keep", group.Key, group.Keep, nil)
+		if group.Adopt {
+			var adoptErr error
+			if !dryRun {
+				adoptErr = e.objects.Set(group.Key, group.Keep.ID)
+			}
+			log.record("adopt", group.Key, group.Keep, adoptErr)
+		}
+
+		for _, obj := range group.Extra {
+			if dryRun {
+				log.record("skip", group.Key, obj, nil)
+				continue
+			}
+			if hardDelete {
+				log.record("delete", group.Key, obj, e.client.DeleteMarkdown(ctx, obj.ID))
+			} else {
+				log.record("archive", group.Key, obj, e.client.ArchiveObjects(ctx, []string{obj.ID}))
+			}
+		}
+	}
+	return log.actions, path, nil
+}
@@ -0,0 +1,361 @@
+package anytypesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// heldDeletesFile is the held deletions file name under the state directory.
+	// Operator decisions are written next to it with a .decision extension.
+	heldDeletesFile = ".anytype-workspace-held-deletes.json"
+
+	// DecisionPollInterval is how often the daemon checks for an operator decision
+	DecisionPollInterval = 5 * time.Second
+)
+
+// HeldDeletion is a deletion paused by the guard until an operator confirms it
+type HeldDeletion struct {
+	Key      string    `json:"key"`
+	ObjectID string    `json:"objectId"`
+	Path     string    `json:"path"`
+	HeldAt   time.Time `json:"heldAt"`
+}
+
+// heldDeletes is the on-disk form of the guard state
+type heldDeletes struct {
+	Reason    string         `json:"reason"`
+	TrippedAt time.Time      `json:"trippedAt"`
+	Held      []HeldDeletion `json:"held"`
+	Approved  []string       `json:"approved,omitempty"` // keys approved but queued after a failure
+}
+
+// DeleteGuard is a circuit breaker for deletions. When too many files disappear at
+// once, or the workspace root itself is gone, it stops passing deletions through and
+// holds them until an operator approves or discards them. Creates and updates are
+// never affected.
+type DeleteGuard struct {
+	path    string // held deletions file
+	config  DeleteGuardConfig
+	root    string     // workspace root; its disappearance trips the guard
+	objects *ObjectMap // mapped files, for the percentage limit
+
+	mu     sync.Mutex
+	recent []time.Time // deletions passed through within the window
+	state  heldDeletes // tripped when Reason is set
+}
+
+// NewDeleteGuard creates a guard that keeps held deletions at path and reloads
+// the ones held by a previous run. objects may be nil to skip the percentage limit.
+func NewDeleteGuard(path string, config DeleteGuardConfig, root string, objects *ObjectMap) (*DeleteGuard, error) {
+	g := &DeleteGuard{path: path, config: config, root: root, objects: objects}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return g, nil
+		}
+		return nil, fmt.Errorf("failed to read held deletions: %w", err)
+	}
+	if err := json.Unmarshal(data, &g.state); err != nil {
+		return nil, fmt.Errorf("failed to parse held deletions: %w", err)
+	}
+	return g, nil
+}
+
+// OpenDeleteGuard opens the held deletions of the service configured by config,
+// for commands that run alongside it without opening its state database
+func OpenDeleteGuard(config *Config) (*DeleteGuard, error) {
+	return NewDeleteGuard(config.statePath(heldDeletesFile), config.DeleteGuard, config.WorkspaceDir, nil)
+}
+
+// Check decides whether a deletion may go ahead. It returns true when the deletion
+// has been held instead.
+func (g *DeleteGuard) Check(key, objectID, path string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// The operator already approved this one; its first attempt failed
+	if i := slices.Index(g.state.Approved, key); i >= 0 {
+		g.state.Approved = slices.Delete(g.state.Approved, i, i+1)
+		g.save()
+		return false
+	}
+
+	now := time.Now()
+	if g.state.Reason == "" {
+		g.trim(now)
+		if reason := g.tripReason(len(g.recent) + 1); reason != "" {
+			g.state.Reason = reason
+			g.state.TrippedAt = now
+			deleteLog.Warn("Deletions paused; run 'anytype-workspace-sync deletions approve' or 'deletions discard'", "reason", reason)
+		}
+	}
+
+	if g.state.Reason != "" {
+		g.state.Held = append(g.state.Held, HeldDeletion{Key: key, ObjectID: objectID, Path: path, HeldAt: now})
+		g.save()
+		return true
+	}
+
+	g.recent = append(g.recent, now)
+	return false
+}
+
+// tripReason returns why the guard should trip for the pending deletion, if at all
+func (g *DeleteGuard) tripReason(deletes int) string {
+	if _, err := os.Stat(g.root); err != nil {
+		return fmt.Sprintf("workspace root %s is gone: %v", g.root, err)
+	}
+
+	limits := g.config
+	window := time.Duration(limits.Window)
+	if limits.MaxDeletes > 0 && deletes > limits.MaxDeletes {
+		return fmt.Sprintf("%d deletions within %s (limit %d)", deletes, window, limits.MaxDeletes)
+	}
+
+	// Deletions already passed through have left the map, so add them back to
+	// compare against the size before the burst started
+	if limits.MaxPercent > 0 && deletes > limits.MinDeletes && g.objects != nil {
+		total := g.objects.Len() + len(g.recent)
+		if percent := 100 * float64(deletes) / float64(total); total > 0 && percent > limits.MaxPercent {
+			return fmt.Sprintf("%.0f%% of mapped files deleted within %s (limit %.0f%%)", percent, window, limits.MaxPercent)
+		}
+	}
+	return ""
+}
+
+// trim forgets deletions that fell out of the window
+func (g *DeleteGuard) trim(now time.Time) {
+	cutoff := now.Add(-time.Duration(g.config.Window))
+	i := 0
+	for i < len(g.recent) && g.recent[i].Before(cutoff) {
+		i++
+	}
+	g.recent = g.recent[i:]
+}
+
+// Release drops a held deletion because its file is back on disk
+func (g *DeleteGuard) Release(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	held := g.state.Held[:0]
+	for _, h := range g.state.Held {
+		if h.Key != key {
+			held = append(held, h)
+		}
+	}
+	approved := slices.DeleteFunc(g.state.Approved, func(k string) bool { return k == key })
+	if len(held) != len(g.state.Held) || len(approved) != len(g.state.Approved) {
+		g.state.Held, g.state.Approved = held, approved
+		g.save()
+	}
+}
+
+// rekey gives every held deletion the key key returns for it
+func (g *DeleteGuard) rekey(key func(HeldDeletion) string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	changed := false
+	for i, h := range g.state.Held {
+		if k := key(h); k != h.Key {
+			g.state.Held[i].Key, changed = k, true
+		}
+	}
+	if changed {
+		g.save()
+	}
+}
+
+// approve lets a queued deletion of key through the next Check, for an approved
+// deletion that failed and will be retried
+func (g *DeleteGuard) approve(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !slices.Contains(g.state.Approved, key) {
+		g.state.Approved = append(g.state.Approved, key)
+		g.save()
+	}
+}
+
+// State returns why deletions are paused (empty when they are not) and how many are held
+func (g *DeleteGuard) State() (string, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.state.Reason, len(g.state.Held)
+}
+
+// Held returns why and since when deletions are paused, and what is held
+func (g *DeleteGuard) Held() (reason string, trippedAt time.Time, held []HeldDeletion) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.state.Reason, g.state.TrippedAt, append([]HeldDeletion(nil), g.state.Held...)
+}
+
+// decisionPath is where the "deletions" subcommand leaves the operator's decision
+func (g *DeleteGuard) decisionPath() string {
+	return strings.TrimSuffix(g.path, filepath.Ext(g.path)) + ".decision"
+}
+
+// Decide records an operator decision ("approve" or "discard") for the running
+// service, which applies it within DecisionPollInterval
+func (g *DeleteGuard) Decide(decision string) error {
+	switch decision {
+	case "approve", "discard":
+	default:
+		return fmt.Errorf("unknown decision %q", decision)
+	}
+	if err := os.WriteFile(g.decisionPath(), []byte(decision+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to record decision: %w", err)
+	}
+	return nil
+}
+
+// Resolve clears the tripped state and returns the deletions that were held
+func (g *DeleteGuard) Resolve() []HeldDeletion {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	held := g.state.Held
+	g.state = heldDeletes{Approved: g.state.Approved}
+	g.recent = nil
+	g.save()
+	return held
+}
+
+// save persists the guard state; an untripped guard leaves no file behind
+func (g *DeleteGuard) save() {
+	if g.state.Reason == "" && len(g.state.Held) == 0 && len(g.state.Approved) == 0 {
+		if err := os.Remove(g.path); err != nil && !os.IsNotExist(err) {
+			deleteLog.Warn("Failed to remove held deletions file", "path", g.path, "error", err)
+		}
+		return
+	}
+
+	data, err := json.MarshalIndent(g.state, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(g.path), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(g.path, data, 0644)
+	}
+	if err != nil {
+		deleteLog.Warn("Failed to save held deletions", "path", g.path, "error", err)
+	}
+}
+
+// HeldDeletions returns why and since when deletions are paused, and what is held
+func (e *Engine) HeldDeletions() (reason string, trippedAt time.Time, held []HeldDeletion) {
+	return e.guard.Held()
+}
+
+// DecideHeldDeletions records an operator decision for the running service
+func (e *Engine) DecideHeldDeletions(decision string) error {
+	return e.guard.Decide(decision)
+}
+
+// runDeletionDecisions waits for operator decisions written by the "deletions"
+// subcommand and applies them
+func (e *Engine) runDeletionDecisions(ctx context.Context) {
+	g := e.guard
+	ticker := time.NewTicker(DecisionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A decision made while paused waits in its file until syncing resumes
+		if e.Paused() {
+			continue
+		}
+		data, err := os.ReadFile(g.decisionPath())
+		if err != nil {
+			continue
+		}
+		os.Remove(g.decisionPath())
+
+		switch decision := strings.TrimSpace(string(data)); decision {
+		case "approve":
+			held := g.Resolve()
+			deleteLog.Info("Operator approved held deletions", "count", len(held))
+			for _, h := range held {
+				e.applyHeldDeletion(ctx, h)
+			}
+		case "discard":
+			held := g.Resolve()
+			deleteLog.Info("Operator discarded held deletions; objects are kept", "count", len(held))
+		default:
+			deleteLog.Warn("Ignoring unknown deletion decision", "decision", decision)
+		}
+	}
+}
+
+// applyHeldDeletion carries out a deletion the operator approved
+func (e *Engine) applyHeldDeletion(ctx context.Context, h HeldDeletion) {
+	defer e.keys.lock(h.Key)()
+
+	ctx = WithTrigger(ctx, TriggerApproved)
+	start := time.Now()
+	entry := AuditEntry{Path: h.Path, Op: fileOpDelete, ObjectID: h.ObjectID, Outcome: outcomeSkipped}
+
+	// The file may have come back while we were waiting
+	if _, err := os.Stat(h.Path); err == nil {
+		deleteLog.Warn("File is back on disk, not deleting", "path", h.Path, "object_id", h.ObjectID)
+		entry.Detail = "file is back on disk"
+		e.audit(ctx, start, entry, nil)
+		return
+	}
+
+	entry.Detail = e.config.Delete.Policy
+	if e.Paused() {
+		e.guard.approve(h.Key)
+		e.queueOp(OpDelete, h.Path)
+		entry.Outcome = outcomeQueued
+		e.audit(ctx, start, entry, errPaused)
+		return
+	}
+	ctx, done, ok := e.beginOp(ctx)
+	if !ok {
+		e.guard.approve(h.Key)
+		e.queueOp(OpDelete, h.Path)
+		entry.Outcome = outcomeQueued
+		e.audit(ctx, start, entry, errShuttingDown)
+		return
+	}
+	defer done()
+
+	if err := e.removeObject(ctx, h.Key, h.ObjectID, h.Path); err != nil {
+		deleteLog.Error("Delete failed", "path", h.Path, "object_id", h.ObjectID, "error", err)
+		// Retry it later without asking the operator again
+		if isTransient(err) || e.aborted(err) {
+			e.guard.approve(h.Key)
+			e.queueOp(OpDelete, h.Path)
+			countFile(fileOpDelete, h.Path, outcomeQueued)
+			entry.Outcome = outcomeQueued
+			e.audit(ctx, start, entry, err)
+			return
+		}
+		entry.Outcome = outcomeError
+		e.audit(ctx, start, entry, err)
+		return
+	}
+	entry.Outcome = outcomeSuccess
+	e.audit(ctx, start, entry, nil)
+	e.forget(h.Key, h.ObjectID, h.Path)
+}
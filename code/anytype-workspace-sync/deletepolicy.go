@@ -0,0 +1,319 @@
+package anytypesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// tombstoneFile is the tombstone file name under the state directory
+const tombstoneFile = ".anytype-workspace-tombstones.json"
+
+// Tombstone records an object whose file was removed but which has not been hard-deleted yet
+type Tombstone struct {
+	Key       string    `json:"key"`
+	ObjectID  string    `json:"objectId"`
+	Path      string    `json:"path"`
+	Policy    string    `json:"policy"`
+	RemovedAt time.Time `json:"removedAt"`
+}
+
+// tombstoneData is the on-disk form of the tombstone store
+type tombstoneData struct {
+	CollectionID string               `json:"collectionId,omitempty"`
+	Tombstones   map[string]Tombstone `json:"tombstones"` // objectID -> tombstone
+}
+
+// TombstoneStore tracks removed objects until the sweeper deletes them for good
+type TombstoneStore struct {
+	path string
+	mu   sync.Mutex
+	data tombstoneData
+}
+
+// NewTombstoneStore creates a tombstone store at path and loads existing tombstones
+func NewTombstoneStore(path string) (*TombstoneStore, error) {
+	ts := &TombstoneStore{
+		path: path,
+		data: tombstoneData{Tombstones: make(map[string]Tombstone)},
+	}
+
+	if err := ts.load(); err != nil {
+		// If file doesn't exist, that's ok
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to load tombstones: %w", err)
+		}
+	}
+
+	return ts, nil
+}
+
+// Add records a tombstone
+func (ts *TombstoneStore) Add(t Tombstone) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.data.Tombstones[t.ObjectID] = t
+	return ts.save()
+}
+
+// Has reports whether an object is tombstoned
+func (ts *TombstoneStore) Has(objectID string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	_, exists := ts.data.Tombstones[objectID]
+	return exists
+}
+
+// FindByKey returns the most recent tombstone for an object map key
+func (ts *TombstoneStore) FindByKey(key string) (Tombstone, bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var found Tombstone
+	for _, t := range ts.data.Tombstones {
+		if t.Key == key && t.RemovedAt.After(found.RemovedAt) {
+			found = t
+		}
+	}
+	return found, found.ObjectID != ""
+}
+
+// rekey gives every tombstone the key key returns for it
+func (ts *TombstoneStore) rekey(key func(Tombstone) string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	changed := false
+	for id, t := range ts.data.Tombstones {
+		if k := key(t); k != t.Key {
+			t.Key, changed = k, true
+			ts.data.Tombstones[id] = t
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return ts.save()
+}
+
+// Expired returns tombstones older than retention
+func (ts *TombstoneStore) Expired(retention time.Duration) []Tombstone {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var expired []Tombstone
+	for _, t := range ts.data.Tombstones {
+		if time.Since(t.RemovedAt) >= retention {
+			expired = append(expired, t)
+		}
+	}
+	return expired
+}
+
+// Remove drops a tombstone
+func (ts *TombstoneStore) Remove(objectID string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	delete(ts.data.Tombstones, objectID)
+	return ts.save()
+}
+
+// CollectionID returns the remembered "Deleted from disk" collection, if any
+func (ts *TombstoneStore) CollectionID() string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.data.CollectionID
+}
+
+// SetCollectionID remembers the "Deleted from disk" collection
+func (ts *TombstoneStore) SetCollectionID(collectionID string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.data.CollectionID = collectionID
+	return ts.save()
+}
+
+// load reads the tombstones from disk
+func (ts *TombstoneStore) load() error {
+	data, err := os.ReadFile(ts.path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, &ts.data); err != nil {
+		return err
+	}
+	if ts.data.Tombstones == nil {
+		ts.data.Tombstones = make(map[string]Tombstone)
+	}
+	return nil
+}
+
+// save writes the tombstones to disk
+func (ts *TombstoneStore) save() error {
+	data, err := json.MarshalIndent(ts.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Ensure directory exists
+	dir := filepath.Dir(ts.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(ts.path, data, 0644)
+}
+
+// removeObject takes an object out of use according to the configured delete policy.
+// Only the "delete" policy with no retention destroys the object immediately; every
+// other combination leaves a tombstone for the sweeper.
+func (e *Engine) removeObject(ctx context.Context, key, objectID, path string) error {
+	policy := e.config.Delete.Policy
+
+	switch policy {
+	case DeletePolicyDelete:
+		if e.config.Delete.Retention == 0 {
+			return e.client.DeleteMarkdown(ctx, objectID)
+		}
+	case DeletePolicyArchive:
+		if err := e.client.ArchiveObjects(ctx, []string{objectID}); err != nil {
+			return err
+		}
+	case DeletePolicyCollection:
+		collectionID, err := e.deletedCollection(ctx)
+		if err != nil {
+			return err
+		}
+		if err := e.client.AddToCollection(ctx, collectionID, []string{objectID}); err != nil {
+			return err
+		}
+	}
+
+	return e.tombstones.Add(Tombstone{
+		Key:       key,
+		ObjectID:  objectID,
+		Path:      path,
+		Policy:    policy,
+		RemovedAt: time.Now(),
+	})
+}
+
+// restoreObject brings back the tombstoned object for key when its file reappears.
+// It returns the restored object ID.
+func (e *Engine) restoreObject(ctx context.Context, key string) (string, bool) {
+	t, found := e.tombstones.FindByKey(key)
+	if !found {
+		return "", false
+	}
+
+	var err error
+	switch t.Policy {
+	case DeletePolicyArchive:
+		err = e.client.UnarchiveObjects(ctx, []string{t.ObjectID})
+	case DeletePolicyCollection:
+		if collectionID := e.tombstones.CollectionID(); collectionID != "" {
+			err = e.client.RemoveFromCollection(ctx, collectionID, []string{t.ObjectID})
+		}
+	}
+	if err != nil {
+		deleteLog.Warn("Failed to restore object", "key", key, "object_id", t.ObjectID, "error", err)
+		return "", false
+	}
+
+	if err := e.tombstones.Remove(t.ObjectID); err != nil {
+		deleteLog.Warn("Failed to update tombstones", "object_id", t.ObjectID, "error", err)
+	}
+
+	deleteLog.Info("Restored object", "key", key, "object_id", t.ObjectID, "removed_at", t.RemovedAt)
+	return t.ObjectID, true
+}
+
+// deletedCollection returns the "Deleted from disk" collection, creating it on first use
+func (e *Engine) deletedCollection(ctx context.Context) (string, error) {
+	if collectionID := e.tombstones.CollectionID(); collectionID != "" {
+		return collectionID, nil
+	}
+
+	collectionID, err := e.client.CreateCollection(ctx, e.config.Delete.CollectionName, e.config.SpaceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %q collection: %w", e.config.Delete.CollectionName, err)
+	}
+	if err := e.tombstones.SetCollectionID(collectionID); err != nil {
+		return "", err
+	}
+	return collectionID, nil
+}
+
+// runSweeper hard-deletes tombstoned objects once their retention period has passed
+func (e *Engine) runSweeper(ctx context.Context) {
+	retention := time.Duration(e.config.Delete.Retention)
+	if retention == 0 {
+		// Keep removed objects forever
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(e.config.Delete.SweepInterval))
+	defer ticker.Stop()
+
+	for {
+		e.sweep(ctx, retention)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep deletes every expired tombstoned object. While syncing is paused it
+// leaves them to a later sweep.
+func (e *Engine) sweep(ctx context.Context, retention time.Duration) {
+	ctx = WithTrigger(ctx, TriggerSweep)
+	for _, t := range e.tombstones.Expired(retention) {
+		if e.Paused() {
+			deleteLog.Debug("Sweep deferred while sync is paused")
+			return
+		}
+		e.purge(ctx, t)
+	}
+}
+
+// purge hard-deletes the object of an expired tombstone. It holds the key's lock
+// so a file coming back can't restore the object while it is being deleted.
+func (e *Engine) purge(ctx context.Context, t Tombstone) {
+	defer e.keys.lock(t.Key)()
+
+	// Restored while waiting for the lock
+	if !e.tombstones.Has(t.ObjectID) {
+		return
+	}
+
+	start := time.Now()
+	entry := AuditEntry{Path: t.Path, Op: auditOpPurge, ObjectID: t.ObjectID, Outcome: outcomeError, Detail: "removed " + t.RemovedAt.Format(time.RFC3339)}
+
+	err := e.client.DeleteMarkdown(ctx, t.ObjectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		deleteLog.Error("Sweeper failed to delete object", "key", t.Key, "object_id", t.ObjectID, "error", err)
+		e.audit(ctx, start, entry, err)
+		return
+	}
+	entry.Outcome = outcomeSuccess
+	e.audit(ctx, start, entry, nil)
+
+	if err := e.tombstones.Remove(t.ObjectID); err != nil {
+		deleteLog.Warn("Failed to update tombstones", "object_id", t.ObjectID, "error", err)
+	}
+	deleteLog.Info("Sweeper deleted object", "key", t.Key, "object_id", t.ObjectID, "removed_at", t.RemovedAt)
+}
@@ -0,0 +1,1412 @@
+package anytypesync_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anyproto/anytype-heart/pb"
+	"github.com/gogo/protobuf/types"
+	anytypesync "github.com/robouden/anytype-workspace-sync"
+	"github.com/robouden/anytype-workspace-sync/internal/fakeanytype"
+	bolt "go.etcd.io/bbolt"
+)
+
+const testSpaceID = "fake-space"
+
+// syncTimeout bounds each wait; the watcher alone debounces for two seconds
+const syncTimeout = 15 * time.Second
+
+// event is a hook call recorded by a test
+type event struct {
+	kind     string // "synced", "removed", "queued" or "failed"
+	path     string
+	objectID string
+}
+
+// harness is an engine running against a fake AnyType server
+type harness struct {
+	t      *testing.T
+	fake   *fakeanytype.Server
+	client *anytypesync.AnyTypeClient
+	engine *anytypesync.Engine
+	dir    string
+	events chan event
+	stop   func() // cancels the engine and waits for Run to return
+}
+
+// testConfig returns a config that keeps all state in temporary directories and
+// fails fast, so outages are noticed within a test's patience
+func testConfig(t *testing.T) *anytypesync.Config {
+	config := anytypesync.DefaultConfig()
+	config.WorkspaceDir = t.TempDir()
+	config.StateDir = t.TempDir()
+	config.SpaceID = testSpaceID
+	config.GRPCAddr = fakeanytype.Addr
+
+	config.Auth.AppKeyFile = filepath.Join(config.StateDir, "appkey")
+	if err := os.WriteFile(config.Auth.AppKeyFile, []byte(fakeanytype.AppKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []*anytypesync.RetryPolicy{&config.Retry.Default, &config.Retry.Create, &config.Retry.Update, &config.Retry.Upload, &config.Retry.Delete} {
+		p.MaxAttempts = 1
+		p.AttemptTimeout = anytypesync.Duration(2 * time.Second)
+	}
+	config.Health.Interval = anytypesync.Duration(time.Hour)
+	return config
+}
+
+// start connects to a fresh fake server and runs an engine until the test ends.
+// files are written to the workspace before the initial sync.
+func start(t *testing.T, config *anytypesync.Config, files map[string]string) *harness {
+	t.Helper()
+
+	fake := fakeanytype.New(testSpaceID)
+	t.Cleanup(fake.Close)
+	return startWith(t, fake, config, files)
+}
+
+// startWith runs an engine against fake until the test ends. When fake refuses
+// connections the space is left for the health monitor to open, as the service does.
+func startWith(t *testing.T, fake *fakeanytype.Server, config *anytypesync.Config, files map[string]string) *harness {
+	t.Helper()
+
+	// The client reads its first session token from ~/.anytype/config.json
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".anytype"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tokenConfig := `{"sessionToken": "` + fake.Token() + `"}`
+	if err := os.WriteFile(filepath.Join(home, ".anytype", "config.json"), []byte(tokenConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := anytypesync.NewAnyTypeClient(config, fake.DialOption())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := client.OpenSpace(ctx, config.SpaceID); err != nil && !fake.Refusing() {
+		t.Fatalf("open space: %v", err)
+	}
+
+	h := &harness{t: t, fake: fake, client: client, dir: config.WorkspaceDir, events: make(chan event, 100)}
+	hooks := anytypesync.Hooks{
+		Synced:  func(path, objectID string) { h.events <- event{"synced", path, objectID} },
+		Removed: func(path, objectID string) { h.events <- event{"removed", path, objectID} },
+		Queued:  func(op anytypesync.PendingOp) { h.events <- event{kind: "queued", path: op.Path} },
+		Failed:  func(path string, err error) { h.events <- event{kind: "failed", path: path} },
+	}
+	h.engine, err = anytypesync.NewEngine(config, client, hooks)
+	if err != nil {
+		t.Fatalf("new engine: %v", err)
+	}
+	// Runs after stop, registered below
+	t.Cleanup(func() { h.engine.Close() })
+
+	for name, content := range files {
+		h.write(name, content)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := h.engine.Run(ctx); err != nil {
+			t.Errorf("run: %v", err)
+		}
+	}()
+	h.stop = func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(h.stop)
+	return h
+}
+
+// write creates or replaces a workspace file, creating its directory
+func (h *harness) write(name, content string) {
+	h.t.Helper()
+	path := filepath.Join(h.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		h.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// wait returns the next hook call of kind for the workspace file name, failing
+// the test on a timeout
+func (h *harness) wait(kind, name string) event {
+	h.t.Helper()
+
+	path := filepath.Join(h.dir, name)
+	timeout := time.After(syncTimeout)
+	for {
+		select {
+		case e := <-h.events:
+			if e.path == path && e.kind == kind {
+				return e
+			}
+		case <-timeout:
+			h.t.Fatalf("timed out waiting for %s of %s", kind, name)
+		}
+	}
+}
+
+// waitCalls waits until the fake server has seen at least n calls of rpc
+func (h *harness) waitCalls(rpc string, n int) {
+	h.t.Helper()
+
+	deadline := time.Now().Add(syncTimeout)
+	for h.fake.Calls(rpc) < n {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %d %s calls", n, rpc)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitStreams waits until the fake server has n open event streams
+func (h *harness) waitStreams(n int) {
+	h.t.Helper()
+
+	deadline := time.Now().Add(syncTimeout)
+	for h.fake.Streams() != n {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %d event streams", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stringValue wraps a string in a protobuf value
+func stringValue(s string) *types.Value {
+	return &types.Value{Kind: &types.Value_StringValue{StringValue: s}}
+}
+
+// object returns the fake server's copy of an object, failing when it is missing
+func (h *harness) object(id string) fakeanytype.Object {
+	h.t.Helper()
+
+	obj, ok := h.fake.Object(id)
+	if !ok {
+		h.t.Fatalf("object %s does not exist", id)
+	}
+	return obj
+}
+
+func TestSyncCreateUpdateDelete(t *testing.T) {
+	h := start(t, testConfig(t), map[string]string{"existing.md": "# Existing\n\nbody"})
+
+	// Files present at startup are picked up by the initial sync
+	existing := h.wait("synced", "existing.md")
+	if obj := h.object(existing.objectID); obj.Name() != "Existing" || obj.Type != "ot-note" {
+		t.Errorf("existing.md synced as %q (%s)", obj.Name(), obj.Type)
+	}
+
+	// New files are picked up by the watcher
+	h.write("new.md", "# New page\n\nfirst draft")
+	created := h.wait("synced", "new.md")
+	if obj := h.object(created.objectID); obj.Description() != "# New page\n\nfirst draft" {
+		t.Errorf("new.md content = %q", obj.Description())
+	}
+	if id, ok := h.engine.Objects().Get("new.md"); !ok || id != created.objectID {
+		t.Errorf("object map has new -> %q, want %q", id, created.objectID)
+	}
+
+	// Saving again updates the same object instead of creating another
+	h.write("new.md", "# Renamed page\n\nsecond draft")
+	updated := h.wait("synced", "new.md")
+	if updated.objectID != created.objectID {
+		t.Errorf("update created object %s, want %s", updated.objectID, created.objectID)
+	}
+	if obj := h.object(created.objectID); obj.Name() != "Renamed page" || obj.Description() != "# Renamed page\n\nsecond draft" {
+		t.Errorf("new.md after update = %q: %q", obj.Name(), obj.Description())
+	}
+	if n := len(h.fake.Objects("ot-note")); n != 2 {
+		t.Errorf("%d notes in the space, want 2", n)
+	}
+
+	// Binary files are uploaded
+	h.write("photo.png", "not really a png")
+	uploaded := h.wait("synced", "photo.png")
+	if obj := h.object(uploaded.objectID); obj.Type != "ot-image" {
+		t.Errorf("photo.png uploaded as %s", obj.Type)
+	}
+
+	// Deleting a file archives its object and drops the mapping
+	if err := os.Remove(filepath.Join(h.dir, "new.md")); err != nil {
+		t.Fatal(err)
+	}
+	removed := h.wait("removed", "new.md")
+	if removed.objectID != created.objectID {
+		t.Errorf("removed object %s, want %s", removed.objectID, created.objectID)
+	}
+	if obj := h.object(created.objectID); !obj.Archived {
+		t.Error("object of deleted file was not archived")
+	}
+	if _, ok := h.engine.Objects().Get("new.md"); ok {
+		t.Error("object map still has the deleted file")
+	}
+}
+
+func TestExpiredTokenIsRenewed(t *testing.T) {
+	h := start(t, testConfig(t), nil)
+
+	// Let the startup health check finish (its last call is the permission
+	// search), so only the sync below runs into the expired token
+	h.waitCalls("ObjectSearch", 1)
+	h.fake.ExpireToken()
+
+	// The first attempt is rejected; the client creates a session with its app
+	// key and retries
+	h.write("note.md", "# Note")
+	synced := h.wait("synced", "note.md")
+	if _, ok := h.fake.Object(synced.objectID); !ok {
+		t.Fatal("note.md was not created after renewing the token")
+	}
+	if n := h.fake.Calls("WalletCreateSession"); n != 1 {
+		t.Errorf("%d sessions created, want 1", n)
+	}
+}
+
+func TestOutageIsQueuedAndReplayed(t *testing.T) {
+	config := testConfig(t)
+	config.Breaker.MinRequests = 1
+	config.Breaker.OpenDuration = anytypesync.Duration(200 * time.Millisecond)
+	config.Health.Interval = anytypesync.Duration(100 * time.Millisecond)
+	config.Health.DownAfter = 1
+	h := start(t, config, nil)
+
+	h.fake.SetDown(true)
+
+	h.write("offline.md", "# Written offline")
+	h.wait("queued", "offline.md")
+	if n := len(h.fake.Objects("ot-note")); n != 0 {
+		t.Fatalf("%d notes created during the outage", n)
+	}
+
+	// The health monitor notices the recovery and the queue is replayed
+	h.fake.SetDown(false)
+	synced := h.wait("synced", "offline.md")
+	if obj := h.object(synced.objectID); obj.Name() != "Written offline" {
+		t.Errorf("replayed note is named %q", obj.Name())
+	}
+	if pending := h.engine.Pending(); len(pending) != 0 {
+		t.Errorf("%d operations still queued", len(pending))
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	h := start(t, testConfig(t), map[string]string{"remote.md": "# Remote"})
+	synced := h.wait("synced", "remote.md")
+	h.waitStreams(1)
+
+	events, unsubscribe := h.client.Subscribe(10)
+	defer unsubscribe()
+
+	next := func() anytypesync.Event {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(syncTimeout):
+			t.Fatal("timed out waiting for an event")
+			return anytypesync.Event{}
+		}
+	}
+
+	// A rename in another client arrives as a details amendment
+	h.fake.Emit(&pb.Event{ContextId: synced.objectID, Messages: []*pb.EventMessage{{
+		SpaceId: testSpaceID,
+		Value: &pb.EventMessageValueOfObjectDetailsAmend{ObjectDetailsAmend: &pb.EventObjectDetailsAmend{
+			Id:      synced.objectID,
+			Details: []*pb.EventObjectDetailsAmendKeyValue{{Key: "name", Value: &types.Value{Kind: &types.Value_StringValue{StringValue: "Renamed remotely"}}}},
+		}},
+	}}})
+	if ev := next(); ev.Kind != anytypesync.EventDetailsAmend || ev.ObjectID != synced.objectID || ev.Details["name"].GetStringValue() != "Renamed remotely" {
+		t.Errorf("got %+v, want a name amendment of %s", ev, synced.objectID)
+	}
+
+	// The stream is reopened after an outage
+	h.fake.SetDown(true)
+	h.waitStreams(0)
+	h.fake.SetDown(false)
+	h.waitStreams(1)
+
+	// Deleting the object in another client drops its mapping
+	h.fake.RemoveObject(synced.objectID)
+	if ev := next(); ev.Kind != anytypesync.EventObjectRemoved || ev.ObjectID != synced.objectID {
+		t.Errorf("got %+v, want removal of %s", ev, synced.objectID)
+	}
+	deadline := time.Now().Add(syncTimeout)
+	for {
+		if _, ok := h.engine.Objects().Get("remote.md"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("mapping of the remotely deleted object was kept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOwnChangesAreNotRemote(t *testing.T) {
+	h := start(t, testConfig(t), map[string]string{"mine.md": "# Mine", "other.md": "# Other"})
+	mine := h.wait("synced", "mine.md")
+	other := h.wait("synced", "other.md")
+	h.waitStreams(1)
+
+	// The server echoes the sync's own write back on the event stream
+	h.fake.Emit(&pb.Event{ContextId: mine.objectID, Messages: []*pb.EventMessage{{
+		SpaceId: testSpaceID,
+		Value: &pb.EventMessageValueOfObjectDetailsAmend{ObjectDetailsAmend: &pb.EventObjectDetailsAmend{
+			Id:      mine.objectID,
+			Details: []*pb.EventObjectDetailsAmendKeyValue{{Key: "name", Value: &types.Value{Kind: &types.Value_StringValue{StringValue: "Mine"}}}},
+		}},
+	}}})
+
+	// Events are handled in order, so once the removal of other.md is seen the
+	// echo has been handled too
+	h.fake.RemoveObject(other.objectID)
+	deadline := time.Now().Add(syncTimeout)
+	for {
+		if _, ok := h.engine.Objects().ByObjectID(other.objectID); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("removal of other.md was not handled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if state, _ := h.engine.Objects().ByObjectID(mine.objectID); !state.RemoteModified.IsZero() {
+		t.Errorf("own write recorded as a remote change at %s", state.RemoteModified)
+	}
+}
+
+func TestTokenFileIsReloaded(t *testing.T) {
+	h := start(t, testConfig(t), nil)
+	h.waitCalls("ObjectSearch", 1)
+
+	// The server hands out a new token and writes it to the CLI config, as a
+	// restarted "anytype serve" does
+	h.fake.ExpireToken()
+	tokenConfig := `{"sessionToken": "` + h.fake.Token() + `"}`
+	if err := os.WriteFile(filepath.Join(os.Getenv("HOME"), ".anytype", "config.json"), []byte(tokenConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// HealthCheck makes no recovery attempts, so it passes once the file is reloaded
+	deadline := time.Now().Add(syncTimeout)
+	for h.client.HealthCheck(context.Background()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("new token from the CLI config was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	h.write("note.md", "# Note")
+	h.wait("synced", "note.md")
+	if n := h.fake.Calls("WalletCreateSession"); n != 0 {
+		t.Errorf("%d sessions created, want 0", n)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	h := start(t, testConfig(t), map[string]string{"counted.md": "# Counted"})
+	h.wait("synced", "counted.md")
+
+	rec := httptest.NewRecorder()
+	anytypesync.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		`anytype_sync_files_total{op="create",outcome="success",type="markdown"}`,
+		`anytype_sync_rpc_duration_seconds_count{code="OK",method="ObjectCreate"}`,
+		"anytype_sync_queue_depth 0",
+		"anytype_sync_last_success_timestamp_seconds",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
+
+func TestControlAPI(t *testing.T) {
+	h := start(t, testConfig(t), nil)
+	api := httptest.NewServer(h.engine.ControlHandler(context.Background()))
+	defer api.Close()
+
+	call := func(method, path string, out any) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, api.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	// Follow activity as server-sent events
+	resp, err := http.Get(api.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	stream := bufio.NewScanner(resp.Body)
+	nextEvent := func() string {
+		t.Helper()
+		for stream.Scan() {
+			if kind, ok := strings.CutPrefix(stream.Text(), "event: "); ok {
+				return kind
+			}
+		}
+		t.Fatalf("event stream ended: %v", stream.Err())
+		return ""
+	}
+
+	// Changes made while paused wait in the queue
+	call("POST", "/pause", nil)
+	if kind := nextEvent(); kind != "paused" {
+		t.Fatalf("got %s event, want paused", kind)
+	}
+	h.write("held.md", "# Held")
+	h.wait("queued", "held.md")
+	var pending []anytypesync.PendingOp
+	if call("GET", "/queue", &pending); len(pending) != 1 || pending[0].Path != filepath.Join(h.dir, "held.md") {
+		t.Errorf("queue = %+v, want held.md", pending)
+	}
+	var status anytypesync.Status
+	if call("GET", "/status", &status); !status.Paused {
+		t.Error("status does not say paused")
+	}
+
+	// Resuming replays them
+	call("POST", "/resume", nil)
+	synced := h.wait("synced", "held.md")
+	for _, want := range []string{"queued", "resumed", "synced"} {
+		if kind := nextEvent(); kind != want {
+			t.Errorf("got %s event, want %s", kind, want)
+		}
+	}
+
+	var mapping map[string]string
+	if code := call("GET", "/map/held.md", &mapping); code != http.StatusOK || mapping["objectId"] != synced.objectID {
+		t.Errorf("GET /map/held.md = %d %v, want %s", code, mapping, synced.objectID)
+	}
+	if code := call("GET", "/map/missing.md", nil); code != http.StatusNotFound {
+		t.Errorf("GET /map/missing.md = %d, want 404", code)
+	}
+
+	// A resync of one file recreates its object after it was deleted in AnyType
+	h.fake.RemoveObject(synced.objectID)
+	var resynced map[string]string
+	if code := call("POST", "/resync?path=held.md", &resynced); code != http.StatusOK || resynced["objectId"] == "" {
+		t.Errorf("POST /resync = %d %v", code, resynced)
+	}
+	if code := call("POST", "/resync?path=../outside.md", nil); code != http.StatusBadRequest {
+		t.Errorf("resync outside the workspace = %d, want 400", code)
+	}
+}
+
+func TestControlAPIOverTCP(t *testing.T) {
+	// Find a free port for the service to listen on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	config := testConfig(t)
+	config.Control.Enabled = true
+	config.Control.Addr = addr
+	h := start(t, config, map[string]string{"a.md": "# A"})
+	h.wait("synced", "a.md")
+
+	var token string
+	deadline := time.Now().Add(syncTimeout)
+	for token == "" {
+		token, _ = anytypesync.ReadControlToken(config)
+		if time.Now().After(deadline) {
+			t.Fatal("control token was not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info, err := os.Stat(filepath.Join(config.StateDir, ".anytype-workspace-control-token")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("token file: %v, %v", info, err)
+	}
+
+	call := func(method, path, auth string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, "http://"+addr+path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Without the token nothing can be read or changed, not even by a page in a browser
+	for _, auth := range []string{"", "Bearer wrong", token} {
+		if code := call("POST", "/pause", auth); code != http.StatusUnauthorized {
+			t.Errorf("pause with %q: %d, want 401", auth, code)
+		}
+	}
+	if h.engine.Paused() {
+		t.Fatal("paused without the token")
+	}
+	if code := call("GET", "/status", "Bearer "+token); code != http.StatusOK {
+		t.Errorf("status with the token: %d", code)
+	}
+
+	// A full resync runs once at a time. Unchanged files are skipped, so a.md
+	// changes to keep the first one busy.
+	h.fake.SetDelay("ObjectSetDetails", 500*time.Millisecond)
+	h.write("a.md", "# A\n\nchanged\n")
+	if code := call("POST", "/resync", "Bearer "+token); code != http.StatusAccepted {
+		t.Fatalf("resync: %d, want 202", code)
+	}
+	if code := call("POST", "/resync", "Bearer "+token); code != http.StatusConflict {
+		t.Errorf("second resync while the first runs: %d, want 409", code)
+	}
+	h.wait("synced", "a.md")
+}
+
+func TestConcurrentSyncsCreateOneObject(t *testing.T) {
+	h := start(t, testConfig(t), nil)
+	h.fake.SetDelay("ObjectCreate", 200*time.Millisecond)
+
+	// The watcher and three callers sync the same new file at once
+	h.write("race.md", "# Race")
+	ids := make([]string, 3)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := h.engine.SyncFile(context.Background(), filepath.Join(h.dir, "race.md"))
+			if err != nil {
+				t.Error(err)
+			}
+			ids[i] = id
+		}()
+	}
+	wg.Wait()
+	h.wait("synced", "race.md")
+
+	if n := len(h.fake.Objects("ot-note")); n != 1 {
+		t.Errorf("%d notes created for one file, want 1", n)
+	}
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Errorf("syncs returned objects %v, want one", ids)
+			break
+		}
+	}
+}
+
+func TestShutdownFinishesInFlightSync(t *testing.T) {
+	h := start(t, testConfig(t), nil)
+	h.fake.SetDelay("ObjectCreate", time.Second)
+	// Created after Run, so the initial sync does not pick it up
+	h.write("slow.md", "# Slow")
+	h.waitCalls("ObjectCreate", 1)
+
+	// Stopping waits for the create and maps its object
+	h.stop()
+	synced := h.wait("synced", "slow.md")
+	if id, ok := h.engine.Objects().Get("slow.md"); !ok || id != synced.objectID {
+		t.Errorf("slow.md mapped to %q, want %q", id, synced.objectID)
+	}
+
+	// The map is on disk for the next start
+	h.engine.Close()
+	engine, err := anytypesync.NewEngine(h.engine.Config(), nil, anytypesync.Hooks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	if id, _ := engine.Objects().Get("slow.md"); id != synced.objectID {
+		t.Errorf("saved map has slow.md as %q, want %q", id, synced.objectID)
+	}
+}
+
+func TestShutdownQueuesAbortedSync(t *testing.T) {
+	config := testConfig(t)
+	config.Shutdown.GracePeriod = anytypesync.Duration(100 * time.Millisecond)
+	h := start(t, config, nil)
+	h.fake.SetDelay("ObjectCreate", time.Minute)
+	h.write("stuck.md", "# Stuck")
+	h.waitCalls("ObjectCreate", 1)
+
+	// The create outlives the grace period, so it is aborted and queued
+	h.stop()
+	h.wait("queued", "stuck.md")
+
+	h.engine.Close()
+	engine, err := anytypesync.NewEngine(config, nil, anytypesync.Hooks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	pending := engine.Pending()
+	if len(pending) != 1 || pending[0].Path != filepath.Join(h.dir, "stuck.md") {
+		t.Errorf("saved queue is %+v, want stuck.md", pending)
+	}
+}
+
+func TestSystemdNotify(t *testing.T) {
+	// Stand in for systemd's notification socket
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "400000")
+
+	messages := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	next := func(prefix string) string {
+		t.Helper()
+		timeout := time.After(syncTimeout)
+		for {
+			select {
+			case m := <-messages:
+				if strings.HasPrefix(m, prefix) {
+					return m
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s", prefix)
+			}
+		}
+	}
+
+	h := start(t, testConfig(t), nil)
+
+	// Ready once the initial sync is done, with a status line for systemctl
+	if ready := next("READY=1"); !strings.Contains(ready, "STATUS=Syncing") {
+		t.Errorf("ready message is %q", ready)
+	}
+
+	// The idle watch loop keeps the watchdog fed
+	next("WATCHDOG=1")
+	next("WATCHDOG=1")
+
+	h.engine.Pause()
+	if status := next("STATUS="); !strings.HasPrefix(status, "STATUS=Paused") {
+		t.Errorf("status after pausing is %q", status)
+	}
+
+	h.stop()
+	next("STOPPING=1")
+}
+
+func TestAuditLog(t *testing.T) {
+	config := testConfig(t)
+	h := start(t, config, map[string]string{"audited.md": "# Audited"})
+	created := h.wait("synced", "audited.md")
+
+	if err := os.Remove(filepath.Join(h.dir, "audited.md")); err != nil {
+		t.Fatal(err)
+	}
+	h.wait("removed", "audited.md")
+
+	entries, err := anytypesync.ReadAudit(config, anytypesync.AuditFilter{Path: "audited.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d audit entries, want 2: %+v", len(entries), entries)
+	}
+
+	sum := sha256.Sum256([]byte("# Audited"))
+	create, remove := entries[0], entries[1]
+	if create.Op != "create" || create.Trigger != anytypesync.TriggerInitial || create.Outcome != "success" ||
+		create.ObjectID != created.objectID || create.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("create entry is %+v", create)
+	}
+	if remove.Op != "delete" || remove.Trigger != anytypesync.TriggerWatch || remove.Outcome != "success" ||
+		remove.ObjectID != created.objectID || remove.Detail != anytypesync.DeletePolicyArchive {
+		t.Errorf("delete entry is %+v", remove)
+	}
+
+	// Filters narrow by outcome and time
+	if entries, _ := anytypesync.ReadAudit(config, anytypesync.AuditFilter{Outcome: "error"}); len(entries) != 0 {
+		t.Errorf("%d entries with outcome error", len(entries))
+	}
+	if entries, _ := anytypesync.ReadAudit(config, anytypesync.AuditFilter{Since: time.Now()}); len(entries) != 0 {
+		t.Errorf("%d entries from the future", len(entries))
+	}
+}
+
+func TestObjectMapRecovery(t *testing.T) {
+	config := testConfig(t)
+	mapPath := filepath.Join(config.StateDir, ".anytype-workspace-objectmap.json")
+	dbPath := filepath.Join(config.StateDir, ".anytype-workspace-state.db")
+	writeState := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open := func(config *anytypesync.Config) *anytypesync.Engine {
+		t.Helper()
+		engine, err := anytypesync.NewEngine(config, nil, anytypesync.Hooks{})
+		if err != nil {
+			t.Fatalf("new engine: %v", err)
+		}
+		return engine
+	}
+
+	// A JSON map cut short by a crash is migrated from its backup, once
+	writeState(mapPath, `{"version": 1, "mappings": {"kept": "obj-`)
+	writeState(mapPath+".1", `{"version": 1, "mappings": {"kept": "obj-1"}}`)
+	engine := open(config)
+	if id, _ := engine.Objects().Get("kept"); id != "obj-1" {
+		t.Errorf("migrated map has kept as %q", id)
+	}
+	if _, err := os.Stat(mapPath + ".migrated"); err != nil {
+		t.Errorf("migrated map not renamed: %v", err)
+	}
+
+	// Concurrent changes all reach the database
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := engine.Objects().Set(fmt.Sprintf("note-%d", i), fmt.Sprintf("obj-note-%d", i)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The service holds the database; a second engine is refused
+	if _, err := anytypesync.NewEngine(config, nil, anytypesync.Hooks{}); err == nil {
+		t.Error("second engine opened the state database in use")
+	}
+	engine.Close()
+
+	engine = open(config)
+	if n := engine.Objects().Len(); n != 51 {
+		t.Errorf("reopened database has %d mappings, want 51", n)
+	}
+	if state, ok := engine.Objects().ByObjectID("obj-note-7"); !ok || state.Key != "note-7" {
+		t.Errorf("obj-note-7 looked up as %+v", state)
+	}
+	// Files sharing an object are each counted, as the deletion guard needs
+	if err := engine.Objects().Set("twin", "obj-1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := engine.Objects().Len(); n != 52 {
+		t.Errorf("%d mappings after mapping a second file to obj-1, want 52", n)
+	}
+	engine.Close()
+
+	// A corrupted database is replaced by its backup and kept aside
+	writeState(dbPath, strings.Repeat("x", 16384))
+	engine = open(config)
+	if id, _ := engine.Objects().Get("kept"); id != "obj-1" {
+		t.Errorf("recovered database has kept as %q", id)
+	}
+	if corrupt, _ := filepath.Glob(dbPath + ".corrupt-*"); len(corrupt) != 1 {
+		t.Errorf("corrupted database not kept aside: %v", corrupt)
+	}
+	engine.Close()
+
+	// Without a readable backup a corrupted database stops startup rather than
+	// starting empty and duplicating every object
+	writeState(dbPath, strings.Repeat("x", 16384))
+	for i := 1; i <= 5; i++ {
+		os.Remove(fmt.Sprintf("%s.%d", dbPath, i))
+	}
+	if _, err := anytypesync.NewEngine(config, nil, anytypesync.Hooks{}); err == nil {
+		t.Error("engine started with a corrupted database and no backup")
+	}
+
+	// Maps written before the JSON format had a version still migrate
+	config = testConfig(t)
+	mapPath = filepath.Join(config.StateDir, ".anytype-workspace-objectmap.json")
+	writeState(mapPath, `{"legacy": "obj-legacy"}`)
+	engine = open(config)
+	if id, _ := engine.Objects().Get("legacy"); id != "obj-legacy" {
+		t.Errorf("legacy map has legacy as %q", id)
+	}
+	engine.Close()
+
+	// Keys from before they were paths move to the path of the one file with
+	// that name; a key with no file keeps its record for reconcile to report
+	moved := testConfig(t)
+	writeState(filepath.Join(moved.WorkspaceDir, "notes", "plan.md"), "# Plan")
+	writeState(filepath.Join(moved.StateDir, ".anytype-workspace-objectmap.json"), `{"version": 1, "mappings": {"plan": "obj-plan", "gone": "obj-gone"}}`)
+	engine = open(moved)
+	if state, ok := engine.Objects().ByObjectID("obj-plan"); !ok || state.Key != "notes/plan.md" {
+		t.Errorf("obj-plan is mapped as %+v, want notes/plan.md", state)
+	}
+	if id, _ := engine.Objects().Get("gone"); id != "obj-gone" {
+		t.Errorf("gone is mapped to %q", id)
+	}
+	engine.Close()
+
+	// And an unreadable JSON map without backups is not silently dropped
+	writeState(mapPath, `{"mappings": `)
+	if _, err := anytypesync.NewEngine(config, nil, anytypesync.Hooks{}); err == nil {
+		t.Error("engine started with a corrupted map and no backup")
+	}
+
+	// Removals reach the backups too
+	removed := testConfig(t)
+	dbPath = filepath.Join(removed.StateDir, ".anytype-workspace-state.db")
+	engine = open(removed)
+	if err := engine.Objects().Set("doomed", "obj-doomed"); err != nil {
+		t.Fatal(err)
+	}
+	engine.Close()
+	hourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(dbPath+".1", hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+	engine = open(removed)
+	if err := engine.Objects().Delete("doomed"); err != nil {
+		t.Fatal(err)
+	}
+	engine.Close()
+	writeState(dbPath, strings.Repeat("x", 16384))
+	engine = open(removed)
+	if id, ok := engine.Objects().Get("doomed"); ok {
+		t.Errorf("backup taken after the removal still maps doomed to %s", id)
+	}
+	engine.Close()
+
+	// A database from a newer version is not a corruption: startup fails and
+	// the database is left alone, however recent the backup
+	db, err := bolt.Open(dbPath, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("meta")).Put([]byte("version"), []byte("99"))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anytypesync.NewEngine(removed, nil, anytypesync.Hooks{}); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("engine with a newer database: %v", err)
+	}
+	if corrupt, _ := filepath.Glob(dbPath + ".corrupt-*"); len(corrupt) != 1 {
+		t.Errorf("newer database was moved aside; corrupted databases kept: %v", corrupt)
+	}
+}
+
+func TestFileState(t *testing.T) {
+	h := start(t, testConfig(t), map[string]string{"note.md": "# Note"})
+	synced := h.wait("synced", "note.md")
+
+	path := filepath.Join(h.dir, "note.md")
+	state, ok := h.engine.Objects().ByObjectID(synced.objectID)
+	if !ok {
+		t.Fatalf("no state for object %s", synced.objectID)
+	}
+	sum := sha256.Sum256([]byte("# Note"))
+	if state.Key != "note.md" || state.Path != path || state.Type != "markdown" || state.SpaceID != h.engine.Config().SpaceID ||
+		state.Hash != hex.EncodeToString(sum[:]) || state.SyncedAt.IsZero() || state.ModTime.IsZero() {
+		t.Errorf("state after sync is %+v", state)
+	}
+	if byPath, ok := h.engine.Objects().ByPath(path); !ok || byPath.ObjectID != synced.objectID {
+		t.Errorf("ByPath(%s) = %+v", path, byPath)
+	}
+
+	// A failed update is recorded on the file
+	h.fake.SetDown(true)
+	h.write("note.md", "# Note\n\nEdited")
+	h.wait("queued", "note.md")
+	if state, _ := h.engine.Objects().State("note.md"); state.LastError == "" || state.LastErrorAt.IsZero() {
+		t.Errorf("state after a failed sync is %+v", state)
+	}
+
+	// The control API serves the state by object ID
+	srv := httptest.NewServer(h.engine.ControlHandler(context.Background()))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/object/" + synced.objectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var served anytypesync.FileState
+	if err := json.NewDecoder(resp.Body).Decode(&served); err != nil || served.Path != path {
+		t.Errorf("GET /object/%s = %+v (%v)", synced.objectID, served, err)
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	config := testConfig(t)
+	opens := time.Now().Add(2 * time.Second)
+	config.Maintenance.Windows = []anytypesync.MaintenanceWindow{
+		{Start: opens.Format(time.TimeOnly), Duration: anytypesync.Duration(6 * time.Second)},
+	}
+	h := start(t, config, nil)
+	activity, unsubscribe := h.engine.SubscribeActivity(100)
+	defer unsubscribe()
+	next := func(kind string) anytypesync.Activity {
+		t.Helper()
+		timeout := time.After(syncTimeout)
+		for {
+			select {
+			case a := <-activity:
+				if a.Kind == kind {
+					return a
+				}
+			case <-timeout:
+				t.Fatalf("no %s activity", kind)
+			}
+		}
+	}
+
+	// The window pauses syncing; changes made in it are queued and coalesced
+	if paused := next(anytypesync.ActivityPaused); paused.Detail != anytypesync.PauseMaintenance {
+		t.Errorf("paused for %q, want maintenance", paused.Detail)
+	}
+	if status := h.engine.Status(); status.PauseReason != anytypesync.PauseMaintenance || status.PausedUntil == nil {
+		t.Errorf("status while in the window is %+v", status)
+	}
+	h.write("during.md", "# During")
+	h.wait("queued", "during.md")
+	if calls := h.fake.Calls("ObjectCreate"); calls != 0 {
+		t.Errorf("%d objects created during the window", calls)
+	}
+
+	// Its end resumes syncing with a summary of what piled up
+	resumed := next(anytypesync.ActivityResumed)
+	h.wait("synced", "during.md")
+	summary := h.engine.LastPause()
+	if summary == nil || summary.Reason != anytypesync.PauseMaintenance || summary.Changes < 1 || summary.Syncs != 1 || summary.Deletes != 0 {
+		t.Errorf("summary after the window is %+v", summary)
+	}
+	if !strings.Contains(resumed.Detail, "1 syncs") {
+		t.Errorf("resumed activity says %q", resumed.Detail)
+	}
+}
+
+func TestApprovedDeletionIsRetried(t *testing.T) {
+	config := testConfig(t)
+	config.DeleteGuard.MaxDeletes = 1
+	config.Breaker.MinRequests = 1
+	config.Breaker.OpenDuration = anytypesync.Duration(200 * time.Millisecond)
+	config.Health.Interval = anytypesync.Duration(100 * time.Millisecond)
+	config.Health.DownAfter = 1
+	h := start(t, config, map[string]string{"first.md": "# First", "second.md": "# Second"})
+	h.wait("synced", "first.md")
+	h.wait("synced", "second.md")
+
+	// The second deletion in the window is held
+	os.Remove(filepath.Join(h.dir, "first.md"))
+	h.wait("removed", "first.md")
+	os.Remove(filepath.Join(h.dir, "second.md"))
+	deadline := time.Now().Add(syncTimeout)
+	for {
+		if _, _, held := h.engine.HeldDeletions(); len(held) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second.md deletion was not held")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Approved during an outage, it is queued rather than dropped
+	h.fake.SetDown(true)
+	if err := h.engine.DecideHeldDeletions("approve"); err != nil {
+		t.Fatal(err)
+	}
+	h.wait("queued", "second.md")
+
+	// and goes through once AnyType is back, without tripping the guard again
+	h.fake.SetDown(false)
+	h.wait("removed", "second.md")
+	if reason, _, held := h.engine.HeldDeletions(); reason != "" || len(held) != 0 {
+		t.Errorf("guard tripped again: %q, %d held", reason, len(held))
+	}
+}
+
+func TestPauseHoldsBackDecisionsAndSurvivesRestart(t *testing.T) {
+	config := testConfig(t)
+	config.DeleteGuard.MaxDeletes = 1
+	h := start(t, config, map[string]string{"first.md": "# First", "second.md": "# Second"})
+	h.wait("synced", "first.md")
+	h.wait("synced", "second.md")
+
+	os.Remove(filepath.Join(h.dir, "first.md"))
+	h.wait("removed", "first.md")
+	os.Remove(filepath.Join(h.dir, "second.md"))
+	deadline := time.Now().Add(syncTimeout)
+	for {
+		if _, _, held := h.engine.HeldDeletions(); len(held) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second.md deletion was not held")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// A decision made while paused is left for after the pause
+	h.engine.Pause()
+	if err := h.engine.DecideHeldDeletions("approve"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(anytypesync.DecisionPollInterval + time.Second)
+	if _, err := os.Stat(filepath.Join(config.StateDir, ".anytype-workspace-held-deletes.decision")); err != nil {
+		t.Errorf("decision was taken while paused: %v", err)
+	}
+	if _, _, held := h.engine.HeldDeletions(); len(held) != 1 {
+		t.Errorf("%d deletions held while paused, want 1", len(held))
+	}
+	h.engine.Resume()
+	h.wait("removed", "second.md")
+
+	// A manual pause outlasts a restart, until it is resumed
+	h.engine.Pause()
+	h.stop()
+	h.engine.Close()
+	reopen := func() *anytypesync.Engine {
+		t.Helper()
+		engine, err := anytypesync.NewEngine(config, nil, anytypesync.Hooks{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return engine
+	}
+	engine := reopen()
+	if status := engine.Status(); !engine.Paused() || status.PauseReason != anytypesync.PauseManual {
+		t.Errorf("after a restart paused = %t, reason %q", engine.Paused(), status.PauseReason)
+	}
+	engine.Resume()
+	engine.Close()
+
+	engine = reopen()
+	defer engine.Close()
+	if engine.Paused() {
+		t.Error("still paused after resuming and restarting")
+	}
+}
+
+func TestReconcileAndRepair(t *testing.T) {
+	h := start(t, testConfig(t), map[string]string{
+		"a.md":     "# A",
+		"sub/a.md": "# Sub A", // same name as a.md, in a subdirectory
+		"c.md":     "# C",
+		"e.md":     "# E",
+	})
+	ids := map[string]string{}
+	for _, name := range []string{"a.md", "c.md", "e.md", "sub/a.md"} { // initial sync order
+		ids[name] = h.wait("synced", name).objectID
+	}
+	if ids["a.md"] == ids["sub/a.md"] {
+		t.Fatal("files of the same name in different directories share an object")
+	}
+	objects := h.engine.Objects()
+	waitUnmapped := func(key string) {
+		t.Helper()
+		deadline := time.Now().Add(syncTimeout)
+		for {
+			if _, ok := objects.Get(key); !ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s is still mapped", key)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Stale: a.md points at an object that never existed, and gone.md has no file
+	objects.Set("a.md", "no-such-object")
+	objects.Set("gone.md", "also-gone")
+	// Orphaned with its file on disk: sub/a.md lost its mapping
+	objects.Delete("sub/a.md")
+	// Orphaned without a file: c.md lost its mapping, then its file
+	objects.Delete("c.md")
+	os.Remove(filepath.Join(h.dir, "c.md"))
+	// Missing: e.md's object was deleted in AnyType
+	h.fake.RemoveObject(ids["e.md"])
+	waitUnmapped("e.md")
+
+	ctx := context.Background()
+	report, err := h.engine.Reconcile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dangling, orphans, missing []string
+	for _, m := range report.Dangling {
+		dangling = append(dangling, m.Key)
+	}
+	for _, obj := range report.Orphans {
+		orphans = append(orphans, obj.ID)
+	}
+	for _, path := range report.Missing {
+		missing = append(missing, path)
+	}
+	slices.Sort(orphans)
+	slices.Sort(missing)
+	wantOrphans := []string{ids["a.md"], ids["sub/a.md"], ids["c.md"]}
+	slices.Sort(wantOrphans)
+	wantMissing := []string{filepath.Join(h.dir, "e.md"), filepath.Join(h.dir, "sub", "a.md")}
+	if !slices.Equal(dangling, []string{"a.md", "gone.md"}) || !slices.Equal(orphans, wantOrphans) || !slices.Equal(missing, wantMissing) {
+		t.Fatalf("report: dangling %v, orphans %v, missing %v", dangling, orphans, missing)
+	}
+
+	// Repaired in the order the reconcile command uses
+	h.engine.RepairDangling(report)
+	for _, r := range h.engine.RepairOrphans(ctx, report) {
+		want := anytypesync.RepairAdopted
+		if r.ObjectID == ids["c.md"] {
+			want = anytypesync.RepairRemoved
+		}
+		if r.Action != want || r.Err != nil {
+			t.Errorf("orphan %s: %s (%v), want %s", r.ObjectID, r.Action, r.Err, want)
+		}
+	}
+	if results := h.engine.RepairMissing(ctx, report); len(results) != 1 || results[0].Key != "e.md" || results[0].Err != nil {
+		t.Errorf("missing repairs: %+v, want e.md synced", results)
+	}
+
+	for key, want := range map[string]string{"a.md": ids["a.md"], "sub/a.md": ids["sub/a.md"]} {
+		if id, _ := objects.Get(key); id != want {
+			t.Errorf("%s mapped to %q after repair, want %q", key, id, want)
+		}
+	}
+	if obj := h.object(ids["c.md"]); !obj.Archived {
+		t.Error("orphan of a deleted file was not archived")
+	}
+
+	report, err = h.engine.Reconcile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Empty() {
+		t.Errorf("after repair: %+v", report)
+	}
+}
+
+func TestUnchangedFilesAreSkipped(t *testing.T) {
+	h := start(t, testConfig(t), map[string]string{"note.md": "# Note", "photo.png": "first"})
+	note := h.wait("synced", "note.md")
+	photo := h.wait("synced", "photo.png")
+
+	// Syncing files that did not change sends nothing
+	for name, want := range map[string]string{"note.md": note.objectID, "photo.png": photo.objectID} {
+		id, err := h.engine.SyncFile(context.Background(), filepath.Join(h.dir, name))
+		if err != nil || id != want {
+			t.Errorf("sync unchanged %s = %q, %v; want %q", name, id, err, want)
+		}
+	}
+	if n := h.fake.Calls("ObjectSetDetails") + h.fake.Calls("FileUpload"); n != 1 {
+		t.Errorf("%d updates and uploads for unchanged files, want only the first upload", n)
+	}
+
+	// A changed binary file replaces its object, and the old one is removed
+	h.write("photo.png", "second")
+	replaced := h.wait("synced", "photo.png")
+	if replaced.objectID == photo.objectID {
+		t.Fatal("changed photo.png kept its object")
+	}
+	if id, _ := h.engine.Objects().Get("photo.png"); id != replaced.objectID {
+		t.Errorf("object map has photo.png -> %q, want %q", id, replaced.objectID)
+	}
+	if obj := h.object(photo.objectID); !obj.Archived {
+		t.Error("object of the old photo.png was not archived")
+	}
+	if n := len(h.fake.Objects("ot-image")); n != 2 {
+		t.Errorf("%d images in the space, want the new one and the archived one", n)
+	}
+}
+
+func TestDedupeLeavesUserObjects(t *testing.T) {
+	h := start(t, testConfig(t), map[string]string{"plan.md": "# Plan\n\nbody"})
+	synced := h.wait("synced", "plan.md")
+
+	// A copy from before source paths were recorded, a page written by hand and
+	// a note synced from another directory, all with the same title
+	name := map[string]*types.Value{"name": stringValue("Plan")}
+	legacy := h.fake.AddObject(testSpaceID, "ot-note", name)
+	page := h.fake.AddObject(testSpaceID, "ot-page", name)
+	foreign := h.fake.AddObject(testSpaceID, "ot-note", map[string]*types.Value{
+		"name":           stringValue("Plan"),
+		"sourceFilePath": stringValue(filepath.Join(t.TempDir(), "plan.md")),
+	})
+
+	groups, err := h.engine.FindDuplicates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Key != "plan.md" || groups[0].Keep.ID != synced.objectID ||
+		len(groups[0].Extra) != 1 || groups[0].Extra[0].ID != legacy {
+		t.Fatalf("duplicates = %+v, want plan.md keeping %s over %s", groups, synced.objectID, legacy)
+	}
+
+	if _, _, err := h.engine.Dedupe(context.Background(), groups, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.fake.Object(legacy); ok {
+		t.Error("legacy copy was not deleted")
+	}
+	for _, id := range []string{synced.objectID, page, foreign} {
+		if obj := h.object(id); obj.Archived {
+			t.Errorf("%s (%s) was archived", id, obj.Type)
+		}
+	}
+}
+
+func TestSweepSparesRestoredObject(t *testing.T) {
+	config := testConfig(t)
+	config.Delete.Retention = anytypesync.Duration(300 * time.Millisecond)
+	config.Delete.SweepInterval = anytypesync.Duration(50 * time.Millisecond)
+	h := start(t, config, map[string]string{"back.md": "# Back"})
+	synced := h.wait("synced", "back.md")
+
+	path := filepath.Join(h.dir, "back.md")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	h.wait("removed", "back.md")
+
+	// The file comes back while its object is past retention: the sweeper must
+	// wait for the slow restore and then leave the object alone
+	h.fake.SetDelay("ObjectListSetIsArchived", time.Second)
+	h.write("back.md", "# Back")
+	id, err := h.engine.SyncFile(context.Background(), path)
+	if err != nil || id != synced.objectID {
+		t.Fatalf("sync of the returned file = %q, %v; want %s", id, err, synced.objectID)
+	}
+	time.Sleep(2 * time.Duration(config.Delete.SweepInterval))
+	if obj := h.object(synced.objectID); obj.Archived {
+		t.Error("restored object is still archived")
+	}
+}
+
+func TestStartsBeforeAnyType(t *testing.T) {
+	config := testConfig(t)
+	config.Health.Interval = anytypesync.Duration(100 * time.Millisecond)
+	config.Breaker.OpenDuration = anytypesync.Duration(100 * time.Millisecond)
+
+	// AnyType is not listening yet when the service starts
+	fake := fakeanytype.New(testSpaceID)
+	t.Cleanup(fake.Close)
+	fake.SetRefusing(true)
+	h := startWith(t, fake, config, map[string]string{"early.md": "# Early"})
+	h.wait("queued", "early.md")
+
+	// Once it is, the health monitor connects, opens the space and replays the queue
+	fake.SetRefusing(false)
+	synced := h.wait("synced", "early.md")
+	if obj := h.object(synced.objectID); obj.Name() != "Early" {
+		t.Errorf("early.md synced as %q", obj.Name())
+	}
+	deadline := time.Now().Add(syncTimeout)
+	for {
+		state, _, err := h.client.Health()
+		if state == anytypesync.HealthHealthy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("health = %s (%v), want healthy", state, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControlAPIOverSocket(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig(t)
+	config.Control.Enabled = true
+	config.Control.Socket = filepath.Join(dir, "control.sock")
+	h := start(t, config, nil)
+
+	deadline := time.Now().Add(syncTimeout)
+	for !fileExists(config.Control.Socket) {
+		if time.Now().After(deadline) {
+			t.Fatal("control socket was not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Only this user may connect, and nothing else is left next to the socket
+	if info, err := os.Stat(config.Control.Socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("socket: %v, %v", info, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("socket directory holds %d entries, want only the socket", len(entries))
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", config.Control.Socket)
+		},
+	}}
+	resp, err := client.Get("http://control/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status over the socket: %d", resp.StatusCode)
+	}
+
+	h.stop()
+	if fileExists(config.Control.Socket) {
+		t.Error("socket left behind after shutdown")
+	}
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
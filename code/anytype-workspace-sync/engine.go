@@ -0,0 +1,820 @@
+// Package anytypesync mirrors a workspace directory into an AnyType space.
+//
+// An Engine watches the directory and creates, updates and removes AnyType
+// objects as files change, keeping the file → object mapping and the delete,
+// queue and guard state on disk. It talks to AnyType through a Client, normally
+// an *AnyTypeClient connected to "anytype serve" over gRPC. Other programs can
+// embed an Engine to sync files or create pages in-process.
+package anytypesync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// debounceTime is how long a file must be left alone before it is synced
+const debounceTime = 2 * time.Second
+
+// errNotConnected is returned for operations that need AnyType while there is no client
+var errNotConnected = errors.New("client not connected")
+
+// Client is the set of AnyType operations the engine uses. *AnyTypeClient
+// implements it over gRPC.
+type Client interface {
+	SyncMarkdownWithID(ctx context.Context, change *FileChange, spaceID string) (string, error)
+	UpdateMarkdown(ctx context.Context, objectID string, change *FileChange) error
+	UploadFile(ctx context.Context, filePath string, spaceID string) (string, error)
+	DeleteMarkdown(ctx context.Context, objectID string) error
+	ArchiveObjects(ctx context.Context, objectIDs []string) error
+	UnarchiveObjects(ctx context.Context, objectIDs []string) error
+	CreateCollection(ctx context.Context, name string, spaceID string) (string, error)
+	AddToCollection(ctx context.Context, collectionID string, objectIDs []string) error
+	RemoveFromCollection(ctx context.Context, collectionID string, objectIDs []string) error
+	ListSyncedObjects(ctx context.Context, spaceID string, rootDir string) ([]RemoteObject, error)
+	FindObjectsByName(ctx context.Context, spaceID string, names []string) ([]RemoteObject, error)
+	FindObjects(ctx context.Context, spaceID string, objectIDs []string) ([]RemoteObject, error)
+}
+
+// Hooks are called as the engine works; any of them may be nil. They run on the
+// engine's goroutine, so they should return quickly.
+type Hooks struct {
+	// Synced is called after a file was created or updated in AnyType
+	Synced func(path, objectID string)
+	// Removed is called after the object of a deleted file was taken out of use
+	Removed func(path, objectID string)
+	// Queued is called when an operation waits for AnyType to become reachable
+	Queued func(op PendingOp)
+	// Failed is called when syncing or removing a file fails
+	Failed func(path string, err error)
+}
+
+// FileChange represents a markdown file change
+type FileChange struct {
+	Path     string
+	Filename string
+	Title    string
+	Content  string
+}
+
+// Engine syncs a workspace directory into an AnyType space
+type Engine struct {
+	config     *Config
+	client     Client         // nil while AnyType is not connected
+	rpc        *AnyTypeClient // client, when it is the gRPC client
+	hooks      Hooks
+	objects    *ObjectMap
+	tombstones *TombstoneStore
+	guard      *DeleteGuard
+	queue      *PendingQueue
+	activity   activityHub // subscribers to engine activity
+	keys       keyLocks    // one operation at a time per object key
+	pushes     pushLog     // when this process last wrote each object
+	fullSync   atomic.Bool // a sync of every file is running
+	auditLog   auditLog
+	systemd    *notifier   // readiness, status and watchdog for a systemd unit
+	paused     atomic.Bool // changes are queued instead of sent
+	pauseMu    sync.Mutex
+	pause      pauseState // why syncing is paused, guarded by pauseMu
+
+	// Shutdown: operations still running when Run returns are waited for, and
+	// aborted through abort once the grace period is over
+	ops          inflight
+	running      atomic.Bool
+	abort        context.Context
+	abortOps     context.CancelFunc
+	shutdownOnce sync.Once
+	graceTimer   *time.Timer
+
+	fileTimestamps map[string]time.Time
+}
+
+// NewEngine loads the sync state kept under config.StateDir. client may be nil,
+// in which case changes are queued until an engine with a client replays them.
+func NewEngine(config *Config, client Client, hooks Hooks) (*Engine, error) {
+	e := &Engine{
+		config:         config,
+		hooks:          hooks,
+		systemd:        newNotifier(),
+		fileTimestamps: make(map[string]time.Time),
+	}
+	e.abort, e.abortOps = context.WithCancel(context.Background())
+
+	// Keep a nil *AnyTypeClient from turning into a non-nil interface
+	if rpc, ok := client.(*AnyTypeClient); ok {
+		if rpc != nil {
+			e.client, e.rpc = rpc, rpc
+			rpc.OnRecovered(func() { e.queue.TriggerReplay() })
+		}
+	} else {
+		e.client = client
+	}
+
+	var err error
+	e.objects, err = NewObjectMap(config.statePath(stateDBFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize object map: %w", err)
+	}
+
+	e.tombstones, err = NewTombstoneStore(config.statePath(tombstoneFile))
+	if err != nil {
+		e.objects.Close()
+		return nil, fmt.Errorf("failed to initialize tombstones: %w", err)
+	}
+
+	e.guard, err = NewDeleteGuard(config.statePath(heldDeletesFile), config.DeleteGuard, config.WorkspaceDir, e.objects)
+	if err != nil {
+		e.objects.Close()
+		return nil, fmt.Errorf("failed to initialize delete guard: %w", err)
+	}
+
+	e.queue, err = NewPendingQueue(config.statePath(queueFile))
+	if err != nil {
+		e.objects.Close()
+		return nil, fmt.Errorf("failed to initialize queue: %w", err)
+	}
+
+	e.loadPause()
+	e.migrateKeys()
+	return e, nil
+}
+
+// migrateKeys moves state kept under file names without extension, as earlier
+// versions did, to keys relative to the workspace. A record without a path is
+// matched to a file on disk when exactly one has its name.
+func (e *Engine) migrateKeys() {
+	var byName map[string][]string
+	for _, state := range e.objects.States() {
+		to := ""
+		switch {
+		case state.Path != "":
+			to = e.objectKey(state.Path)
+		case !fileExists(filepath.Join(e.config.WorkspaceDir, filepath.FromSlash(state.Key))):
+			if byName == nil {
+				byName = make(map[string][]string)
+				files, _ := workspaceFiles(e.config.WorkspaceDir)
+				for _, file := range files {
+					byName[legacyKey(file)] = append(byName[legacyKey(file)], file)
+				}
+			}
+			if files := byName[state.Key]; len(files) == 1 {
+				to = e.objectKey(files[0])
+			}
+		}
+		if to == "" || to == state.Key {
+			continue
+		}
+		if err := e.objects.Rekey(state.Key, to); err != nil {
+			engineLog.Warn("Failed to move file state to its new key", "key", state.Key, "new_key", to, "error", err)
+			continue
+		}
+		objectMapLog.Info("File state moved to its new key", "key", state.Key, "new_key", to)
+	}
+
+	err := e.tombstones.rekey(func(t Tombstone) string {
+		if t.Path == "" {
+			return t.Key
+		}
+		return e.objectKey(t.Path)
+	})
+	if err != nil {
+		engineLog.Warn("Failed to update tombstone keys", "error", err)
+	}
+	e.guard.rekey(func(h HeldDeletion) string { return e.objectKey(h.Path) })
+}
+
+// Close releases the state database, so another engine can open it. Call it once
+// Run has returned.
+func (e *Engine) Close() error {
+	e.closeAudit()
+	return e.objects.Close()
+}
+
+// Config returns the engine's configuration
+func (e *Engine) Config() *Config {
+	return e.config
+}
+
+// Objects returns the file → object map
+func (e *Engine) Objects() *ObjectMap {
+	return e.objects
+}
+
+// Run starts the background work, syncs the existing files and then watches the
+// workspace until ctx is cancelled. Before returning it lets running operations
+// finish within the shutdown grace period, and saves the queue and object map.
+func (e *Engine) Run(ctx context.Context) error {
+	// Check workspace directory exists
+	if _, err := os.Stat(e.config.WorkspaceDir); err != nil {
+		return fmt.Errorf("workspace directory: %w", err)
+	}
+
+	e.running.Store(true)
+	// Claimed before the control API starts, so a resync cannot run alongside it
+	e.fullSync.Store(true)
+	context.AfterFunc(ctx, e.beginShutdown)
+	defer e.shutdown()
+
+	// Background work: sweep expired tombstones, apply operator decisions on
+	// held deletions, renew the session token ahead of expiry, replay queued
+	// operations, watch server health, follow remote changes, publish status
+	// and metrics, serve the control API and follow the maintenance schedule
+	if e.client != nil {
+		go e.runSweeper(ctx)
+		go e.runDeletionDecisions(ctx)
+	}
+	if e.rpc != nil {
+		go e.rpc.RunTokenRenewal(ctx, time.Duration(e.config.Auth.RenewBefore))
+		go e.rpc.RunHealthMonitor(ctx)
+		go e.rpc.RunEventStream(ctx)
+		go e.runRemoteEvents(ctx)
+	}
+	go e.runStatusWriter(ctx)
+	if e.config.Metrics.Enabled {
+		go e.serveMetrics(ctx)
+	}
+	if e.config.Control.Enabled {
+		go e.serveControl(ctx)
+	}
+	if len(e.config.Maintenance.Windows) > 0 {
+		// A window open at startup holds back the initial sync too
+		e.checkMaintenance(time.Now())
+		go e.runMaintenance(ctx)
+	}
+
+	err := e.syncAll(WithTrigger(ctx, TriggerInitial))
+	e.fullSync.Store(false)
+	if err != nil {
+		return fmt.Errorf("initial sync failed: %w", err)
+	}
+	// Replay only now: the initial sync already covers every file on disk
+	if e.client != nil {
+		go e.runQueueReplayer(ctx)
+	}
+	e.notify("READY=1\nSTATUS=" + e.Status().summary())
+	return e.Watch(ctx)
+}
+
+// isSupported checks if a file type should be synced
+func isSupportedFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	supportedExts := []string{
+		// Markdown
+		".md",
+		// Images
+		".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp", ".svg",
+		// PDFs
+		".pdf",
+		// Videos
+		".mp4", ".mov", ".avi", ".mkv", ".webm",
+		// Audio
+		".mp3", ".wav", ".ogg", ".m4a", ".flac",
+	}
+
+	for _, supported := range supportedExts {
+		if ext == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// objectKey returns the object map key for a file: its path relative to the
+// workspace, with forward slashes, so files of the same name in different
+// directories or with different extensions are kept apart
+func (e *Engine) objectKey(filePath string) string {
+	rel, err := filepath.Rel(e.config.WorkspaceDir, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(filePath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// legacyKey is the object map key used before keys were relative paths: the
+// file name without extension
+func legacyKey(filePath string) string {
+	filename := filepath.Base(filePath)
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
+
+// fileExists reports whether path names an existing file
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// workspaceFiles returns every supported file under dir, including those in
+// subdirectories, which the watcher also follows
+func workspaceFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && isSupportedFile(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// ParseMarkdown extracts title and content from markdown file
+func ParseMarkdown(filepath string) (*FileChange, error) {
+	content, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := strings.TrimSuffix(filepath[strings.LastIndex(filepath, "/")+1:], ".md")
+
+	// Extract first heading as title
+	title := filename
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "# ") {
+			title = strings.TrimPrefix(line, "# ")
+			break
+		}
+	}
+
+	return &FileChange{
+		Path:     filepath,
+		Filename: filename,
+		Title:    title,
+		Content:  string(content),
+	}, nil
+}
+
+// CreatePage creates a note in the space that is not backed by a workspace file
+func (e *Engine) CreatePage(ctx context.Context, title string, content string) (string, error) {
+	if e.client == nil {
+		return "", errNotConnected
+	}
+	return e.client.SyncMarkdownWithID(ctx, &FileChange{Filename: title, Title: title, Content: content}, e.config.SpaceID)
+}
+
+// SyncFile processes a file for sync (markdown or binary). When AnyType cannot be
+// reached, syncing is paused or the engine is shutting down, the file is queued
+// and synced later.
+func (e *Engine) SyncFile(ctx context.Context, filePath string) (string, error) {
+	defer e.keys.lock(e.objectKey(filePath))()
+
+	start := time.Now()
+	entry := AuditEntry{Path: filePath, Op: fileOpCreate, Outcome: outcomeQueued}
+	state, mapped := e.objects.State(e.objectKey(filePath))
+	if mapped {
+		entry.Op, entry.ObjectID = fileOpUpdate, state.ObjectID
+	}
+
+	// Saves without changes and restarts leave synced files alone
+	hash := fileHash(filePath)
+	if e.config.Audit.Enabled {
+		entry.Hash = hash
+	}
+	if mapped && hash != "" && state.Hash == hash && state.Path == filePath && state.LastError == "" {
+		engineLog.Debug("File unchanged since last sync", "path", filePath, "object_id", state.ObjectID)
+		entry.Outcome, entry.Detail = outcomeSkipped, "unchanged"
+		e.audit(ctx, start, entry, nil)
+		return state.ObjectID, nil
+	}
+
+	// Check if client is connected
+	if e.client == nil {
+		e.queueOp(OpSync, filePath)
+		e.audit(ctx, start, entry, errNotConnected)
+		return "", errNotConnected
+	}
+	if e.Paused() {
+		e.queueOp(OpSync, filePath)
+		e.audit(ctx, start, entry, errPaused)
+		return "", errPaused
+	}
+
+	ctx, done, ok := e.beginOp(ctx)
+	if !ok {
+		e.queueOp(OpSync, filePath)
+		e.audit(ctx, start, entry, errShuttingDown)
+		return "", errShuttingDown
+	}
+	defer done()
+
+	objectID, op, err := e.syncFile(ctx, filePath, hash)
+	entry.Op = op
+	if err != nil {
+		e.recordError(filePath, err)
+		if isTransient(err) || e.aborted(err) {
+			e.queueOp(OpSync, filePath)
+			countFile(op, filePath, outcomeQueued)
+		} else {
+			entry.Outcome = outcomeError
+			countFile(op, filePath, outcomeError)
+		}
+		e.audit(ctx, start, entry, err)
+		e.failed(filePath, err)
+		return "", err
+	}
+
+	entry.ObjectID, entry.Outcome = objectID, outcomeSuccess
+	e.audit(ctx, start, entry, nil)
+	countFile(op, filePath, outcomeSuccess)
+	e.synced(filePath, objectID)
+	return objectID, nil
+}
+
+// syncFile sends a file to AnyType and reports whether it created or updated an
+// object. hash is the file's content hash, recorded in its state.
+func (e *Engine) syncFile(ctx context.Context, filePath, hash string) (string, string, error) {
+	// The object map key of the file
+	key := e.objectKey(filePath)
+
+	engineLog.Debug("Syncing file", "path", filePath)
+
+	// Taken before the content is read, so a write during the sync shows as a change
+	var modTime time.Time
+	if info, err := os.Stat(filePath); err == nil {
+		modTime = info.ModTime()
+	}
+
+	var objectID, replacedID string
+	var err error
+
+	// A file that comes back no longer needs its held deletion
+	e.guard.Release(key)
+
+	// A file that comes back before the sweeper ran gets its old object back
+	if _, mapped := e.objects.Get(key); !mapped {
+		if restoredID, ok := e.restoreObject(ctx, key); ok {
+			e.pushes.mark(restoredID)
+			if err := e.objects.Set(key, restoredID); err != nil {
+				engineLog.Warn("Failed to save object mapping", "path", filePath, "object_id", restoredID, "error", err)
+			}
+		}
+	}
+
+	// Handle different file types
+	op := fileOpCreate
+	if strings.HasSuffix(filePath, ".md") {
+		// Markdown file - use existing markdown sync
+		change, parseErr := ParseMarkdown(filePath)
+		if parseErr != nil {
+			engineLog.Error("Failed to parse markdown", "path", filePath, "error", parseErr)
+			return "", op, parseErr
+		}
+
+		// Update the existing object if we have one, so saves don't pile up copies
+		if existingID, mapped := e.objects.Get(key); mapped {
+			op = fileOpUpdate
+			e.pushes.mark(existingID)
+			err = e.client.UpdateMarkdown(ctx, existingID, change)
+			if err == nil {
+				objectID = existingID
+			} else if errors.Is(err, ErrNotFound) {
+				engineLog.Warn("Object no longer exists, creating a new one", "path", filePath, "object_id", existingID)
+				op = fileOpCreate
+				err = nil
+			} else {
+				engineLog.Error("Update failed", "path", filePath, "object_id", existingID, "error", err)
+				return "", op, err
+			}
+		}
+
+		if objectID == "" {
+			objectID, err = e.client.SyncMarkdownWithID(ctx, change, e.config.SpaceID)
+		}
+		if err != nil {
+			engineLog.Error("Sync failed", "path", filePath, "error", err)
+			return "", op, err
+		}
+	} else {
+		// Binary file (image, PDF, video, audio) - use file upload. Uploads
+		// can't replace content, so a changed file becomes a new object and
+		// the old one is removed once the new one is mapped.
+		if existingID, mapped := e.objects.Get(key); mapped {
+			op, replacedID = fileOpUpdate, existingID
+		}
+		objectID, err = e.client.UploadFile(ctx, filePath, e.config.SpaceID)
+		if err != nil {
+			engineLog.Error("Upload failed", "path", filePath, "error", err)
+			return "", op, err
+		}
+	}
+
+	// Store the object ID mapping along with what was synced. A new object is
+	// mapped only now, so events for it before this point are ignored anyway.
+	e.pushes.mark(objectID)
+	err = e.objects.Update(key, func(s *FileState) {
+		if s.ObjectID != objectID {
+			*s = FileState{ObjectID: objectID}
+		}
+		s.Path, s.SpaceID, s.Type = filePath, e.config.SpaceID, fileType(filePath)
+		s.Hash, s.ModTime, s.SyncedAt = hash, modTime, time.Now()
+		s.LastError, s.LastErrorAt = "", time.Time{}
+	})
+	if err != nil {
+		engineLog.Warn("Failed to save object mapping", "path", filePath, "object_id", objectID, "error", err)
+	}
+
+	if replacedID != "" && replacedID != objectID {
+		err := e.removeObject(ctx, key, replacedID, filePath)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			engineLog.Warn("Failed to remove replaced object", "path", filePath, "object_id", replacedID, "error", err)
+		} else {
+			engineLog.Info("Removed replaced object", "path", filePath, "object_id", replacedID)
+		}
+	}
+
+	engineLog.Info("File synced", "path", filePath, "object_id", objectID)
+	return objectID, op, nil
+}
+
+// recordError keeps the error of a failed sync in the state of a mapped file
+func (e *Engine) recordError(filePath string, syncErr error) {
+	key := e.objectKey(filePath)
+	if _, mapped := e.objects.Get(key); !mapped {
+		return
+	}
+	err := e.objects.Update(key, func(s *FileState) {
+		s.LastError, s.LastErrorAt = syncErr.Error(), time.Now()
+	})
+	if err != nil {
+		engineLog.Warn("Failed to save file state", "path", filePath, "error", err)
+	}
+}
+
+// fileHash returns the SHA-256 of a file, or "" when it cannot be read
+func fileHash(filePath string) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// DeleteFile processes a file deletion. Deletions held by the guard, or queued
+// until AnyType is reachable, syncing is not paused and the engine is not
+// shutting down, are not errors.
+func (e *Engine) DeleteFile(ctx context.Context, filePath string) error {
+	// The object map key of the file
+	key := e.objectKey(filePath)
+	defer e.keys.lock(key)()
+
+	engineLog.Debug("Deleting file", "path", filePath)
+
+	// Get object ID from mapping
+	objectID, exists := e.objects.Get(key)
+	start := time.Now()
+	entry := AuditEntry{Path: filePath, Op: fileOpDelete, ObjectID: objectID, Outcome: outcomeQueued}
+
+	// Delete from AnyType via gRPC (if connected and not paused)
+	if e.client == nil || e.Paused() {
+		e.queueOp(OpDelete, filePath)
+		countFile(fileOpDelete, filePath, outcomeQueued)
+		if e.client == nil {
+			e.audit(ctx, start, entry, errNotConnected)
+		} else {
+			e.audit(ctx, start, entry, errPaused)
+		}
+		return nil
+	}
+
+	ctx, done, ok := e.beginOp(ctx)
+	if !ok {
+		e.queueOp(OpDelete, filePath)
+		countFile(fileOpDelete, filePath, outcomeQueued)
+		e.audit(ctx, start, entry, errShuttingDown)
+		return nil
+	}
+	defer done()
+
+	if !exists {
+		engineLog.Warn("No object mapped to file; it may have been deleted already", "path", filePath)
+		entry.Outcome, entry.Detail = outcomeSkipped, "no object mapped"
+		e.audit(ctx, start, entry, nil)
+		return nil
+	}
+
+	// Hold the deletion if it looks like part of a mass deletion
+	if e.guard.Check(key, objectID, filePath) {
+		engineLog.Warn("Deletion held for operator confirmation", "path", filePath, "object_id", objectID)
+		countFile(fileOpDelete, filePath, outcomeHeld)
+		entry.Outcome = outcomeHeld
+		entry.Detail, _ = e.guard.State()
+		e.audit(ctx, start, entry, nil)
+		return nil
+	}
+
+	entry.Detail = e.config.Delete.Policy
+	if err := e.removeObject(ctx, key, objectID, filePath); err != nil {
+		engineLog.Error("Delete failed", "path", filePath, "object_id", objectID, "error", err)
+		if isTransient(err) || e.aborted(err) {
+			e.queueOp(OpDelete, filePath)
+			countFile(fileOpDelete, filePath, outcomeQueued)
+			e.audit(ctx, start, entry, err)
+			return nil
+		}
+		countFile(fileOpDelete, filePath, outcomeError)
+		entry.Outcome = outcomeError
+		e.audit(ctx, start, entry, err)
+		e.failed(filePath, err)
+		return err
+	}
+	entry.Outcome = outcomeSuccess
+	e.audit(ctx, start, entry, nil)
+	e.forget(key, objectID, filePath)
+	countFile(fileOpDelete, filePath, outcomeSuccess)
+	return nil
+}
+
+// keyLocks hands out a mutex per object key, so a file is never synced and deleted,
+// or created twice, by the watcher, the replayer and the control API at once
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the mutex of one key and how many callers hold or wait for it
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock waits for the key and returns the function that releases it
+func (l *keyLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	k, ok := l.locks[key]
+	if !ok {
+		k = &keyLock{}
+		l.locks[key] = k
+	}
+	k.refs++
+	l.mu.Unlock()
+
+	k.Lock()
+	return func() {
+		k.Unlock()
+		l.mu.Lock()
+		if k.refs--; k.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// forget drops the mapping of a file whose object was removed
+func (e *Engine) forget(key, objectID, filePath string) {
+	// Remove from object map
+	if err := e.objects.Delete(key); err != nil {
+		engineLog.Warn("Failed to update object mapping", "path", filePath, "object_id", objectID, "error", err)
+	}
+
+	engineLog.Info("File removed", "path", filePath, "object_id", objectID, "policy", e.config.Delete.Policy)
+	e.removed(filePath, objectID)
+}
+
+// Watch monitors the workspace for file changes until ctx is cancelled
+func (e *Engine) Watch(ctx context.Context) error {
+	dir := e.config.WorkspaceDir
+
+	// Create file watcher
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	// Add root directory
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+
+	// Add subdirectories
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != dir {
+			watcher.Add(path)
+		}
+		return nil
+	})
+
+	engineLog.Info("Watching for changes", "path", dir)
+	ctx = WithTrigger(ctx, TriggerWatch)
+
+	// Wake up now and then so the systemd watchdog hears from an idle loop
+	var heartbeat <-chan time.Time
+	if e.systemd.watchdog > 0 {
+		ticker := time.NewTicker(e.systemd.watchdog / 4)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		e.alive()
+
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-heartbeat:
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			// Only process supported file types
+			if !isSupportedFile(event.Name) {
+				continue
+			}
+
+			// Check if event is recent (debounce)
+			now := time.Now()
+			if lastChange, exists := e.fileTimestamps[event.Name]; exists {
+				if now.Sub(lastChange) < debounceTime {
+					continue
+				}
+			}
+			e.fileTimestamps[event.Name] = now
+
+			// Handle different event types
+			if event.Op&fsnotify.Remove == fsnotify.Remove {
+				// File was deleted
+				e.DeleteFile(ctx, event.Name)
+			} else {
+				// Wait for file to stabilize
+				time.Sleep(debounceTime)
+
+				// Check if file still exists (for create/write events)
+				if _, err := os.Stat(event.Name); err == nil {
+					e.SyncFile(ctx, event.Name)
+				}
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			watcherErrors.WithLabelValues("workspace").Inc()
+			engineLog.Error("Watcher error", "error", err)
+		}
+	}
+}
+
+// InitialSync syncs all existing supported files. Only one runs at a time; it
+// returns an error while another full sync is running.
+func (e *Engine) InitialSync(ctx context.Context) error {
+	if !e.fullSync.CompareAndSwap(false, true) {
+		return errFullSyncRunning
+	}
+	defer e.fullSync.Store(false)
+	return e.syncAll(ctx)
+}
+
+// syncAll syncs all existing supported files; callers must hold fullSync
+func (e *Engine) syncAll(ctx context.Context) error {
+	start := time.Now()
+	engineLog.Info("Running initial sync", "path", e.config.WorkspaceDir)
+
+	files, err := workspaceFiles(e.config.WorkspaceDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		e.alive()
+
+		// Leave the rest to the next start when shutting down
+		if ctx.Err() != nil {
+			engineLog.Info("Initial sync interrupted", "duration", time.Since(start))
+			return nil
+		}
+		e.SyncFile(ctx, file)
+	}
+
+	engineLog.Info("Initial sync complete", "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,154 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error kinds for AnyType calls. Every error returned by the client wraps one of
+// these when the failure could be classified, so callers can use errors.Is.
+// Timeouts wrap context.DeadlineExceeded.
+var (
+	ErrUnauthenticated  = errors.New("not authenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNotFound         = errors.New("not found")
+	ErrUnavailable      = errors.New("AnyType unavailable")
+	ErrInvalidArgument  = errors.New("invalid argument")
+	ErrQuota            = errors.New("quota exceeded")
+	ErrSpaceNotOpen     = errors.New("space not open")
+)
+
+// RPCError is a failed AnyType call
+type RPCError struct {
+	RPC     string // RPC method, e.g. "ObjectCreate"
+	Code    string // gRPC status code or response error code
+	Message string // human-readable explanation
+	Kind    error  // one of the Err* kinds, or nil when unclassified
+	Err     error  // underlying gRPC error, nil for response errors
+}
+
+// Error formats the failure the way the client always has
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s failed: %s (%s)", e.RPC, e.Message, e.Code)
+}
+
+// Unwrap exposes both the kind and the original cause to errors.Is and errors.As
+func (e *RPCError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// grpcCodeKinds maps gRPC status codes to error kinds
+var grpcCodeKinds = map[codes.Code]error{
+	codes.Unauthenticated:   ErrUnauthenticated,
+	codes.PermissionDenied:  ErrPermissionDenied,
+	codes.NotFound:          ErrNotFound,
+	codes.Unavailable:       ErrUnavailable,
+	codes.DeadlineExceeded:  context.DeadlineExceeded,
+	codes.Canceled:          context.Canceled,
+	codes.InvalidArgument:   ErrInvalidArgument,
+	codes.ResourceExhausted: ErrQuota,
+}
+
+// grpcCodeMessages explains the gRPC status codes we commonly see
+var grpcCodeMessages = map[codes.Code]string{
+	codes.Unavailable:      "AnyType service unavailable - check if AnyType is running",
+	codes.DeadlineExceeded: "gRPC call timeout",
+	codes.PermissionDenied: "permission denied - check credentials or space access",
+	codes.NotFound:         "space or object not found",
+	codes.Unauthenticated:  "not authenticated - check network membership",
+}
+
+// responseCodeKinds maps the response error code names shared by AnyType RPCs to
+// error kinds. Each RPC has its own enum, but the names are consistent.
+var responseCodeKinds = map[string]error{
+	"BAD_INPUT":              ErrInvalidArgument,
+	"FAILED_TO_LOAD":         ErrSpaceNotOpen,
+	"NO_SUCH_SPACE":          ErrSpaceNotOpen,
+	"SPACE_IS_DELETED":       ErrSpaceNotOpen,
+	"ACCOUNT_IS_NOT_RUNNING": ErrUnauthenticated,
+	"APP_TOKEN_NOT_FOUND_IN_THE_CURRENT_ACCOUNT": ErrUnauthenticated,
+	"NOT_FOUND":             ErrNotFound,
+	"NOT_ENOUGH_FREE_SPACE": ErrQuota,
+	"FILE_TOO_LARGE":        ErrQuota,
+}
+
+// unknownErrorKinds classifies UNKNOWN_ERROR responses by the well-known anytype-heart
+// errors they carry, which have no dedicated response code
+var unknownErrorKinds = []struct {
+	text string
+	kind error
+}{
+	{"not found", ErrNotFound},
+	{"space not exists", ErrSpaceNotOpen},
+	{"space is deleted", ErrSpaceNotOpen},
+	{"not enough free space", ErrQuota},
+	{"limit exceeded", ErrQuota},
+}
+
+// handleGRPCError converts a failed gRPC call into an RPCError
+func (c *AnyTypeClient) handleGRPCError(rpc string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, ErrCircuitOpen) {
+		return &RPCError{RPC: rpc, Code: "CircuitOpen", Message: "AnyType calls paused after repeated failures", Kind: ErrUnavailable, Err: err}
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return &RPCError{RPC: rpc, Code: "unknown", Message: err.Error(), Err: err}
+	}
+	if st.Code() == codes.OK {
+		return nil
+	}
+
+	message, known := grpcCodeMessages[st.Code()]
+	if !known {
+		message = st.Message()
+	}
+
+	return &RPCError{
+		RPC:     rpc,
+		Code:    st.Code().String(),
+		Message: message,
+		Kind:    grpcCodeKinds[st.Code()],
+		Err:     err,
+	}
+}
+
+// isTransient reports whether an operation failed only because AnyType could not
+// be reached, so it is worth queueing for later
+func isTransient(err error) bool {
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// responseError converts the error embedded in an RPC response into an RPCError
+func responseError(rpc string, code fmt.Stringer, description string) error {
+	name := code.String()
+	kind := responseCodeKinds[name]
+
+	if kind == nil && name == "UNKNOWN_ERROR" {
+		lower := strings.ToLower(description)
+		for _, k := range unknownErrorKinds {
+			if strings.Contains(lower, k.text) {
+				kind = k.kind
+				break
+			}
+		}
+	}
+
+	return &RPCError{RPC: rpc, Code: name, Message: description, Kind: kind}
+}
@@ -0,0 +1,114 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// responseCode stands in for the per-RPC response error enums
+type responseCode string
+
+func (c responseCode) String() string { return string(c) }
+
+func TestGRPCErrorKinds(t *testing.T) {
+	tests := []struct {
+		code      codes.Code
+		kind      error
+		transient bool
+	}{
+		{codes.Unauthenticated, ErrUnauthenticated, false},
+		{codes.PermissionDenied, ErrPermissionDenied, false},
+		{codes.NotFound, ErrNotFound, false},
+		{codes.Unavailable, ErrUnavailable, true},
+		{codes.DeadlineExceeded, context.DeadlineExceeded, true},
+		{codes.Canceled, context.Canceled, false},
+		{codes.InvalidArgument, ErrInvalidArgument, false},
+		{codes.ResourceExhausted, ErrQuota, false},
+		{codes.Internal, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			cause := status.Error(tt.code, "boom")
+			err := (*AnyTypeClient)(nil).handleGRPCError("ObjectCreate", fmt.Errorf("call: %w", cause))
+
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) || rpcErr.RPC != "ObjectCreate" || rpcErr.Code != tt.code.String() {
+				t.Fatalf("got %#v", err)
+			}
+			if rpcErr.Kind != tt.kind {
+				t.Errorf("kind = %v, want %v", rpcErr.Kind, tt.kind)
+			}
+			if tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Errorf("errors.Is(err, %v) = false", tt.kind)
+			}
+			// The gRPC status stays reachable through the RPCError
+			if st, ok := status.FromError(errors.Unwrap(rpcErr.Err)); !ok || st.Code() != tt.code {
+				t.Errorf("cause lost: %v", rpcErr.Err)
+			}
+			if got := isTransient(err); got != tt.transient {
+				t.Errorf("isTransient = %t, want %t", got, tt.transient)
+			}
+		})
+	}
+}
+
+func TestGRPCErrorSpecialCases(t *testing.T) {
+	c := (*AnyTypeClient)(nil)
+	if err := c.handleGRPCError("ObjectCreate", nil); err != nil {
+		t.Errorf("nil error became %v", err)
+	}
+
+	open := c.handleGRPCError("ObjectCreate", fmt.Errorf("intercepted: %w", ErrCircuitOpen))
+	if !errors.Is(open, ErrUnavailable) || !errors.Is(open, ErrCircuitOpen) || !isTransient(open) {
+		t.Errorf("open breaker error %v is not a transient unavailable error", open)
+	}
+
+	plain := c.handleGRPCError("ObjectCreate", errors.New("no status"))
+	var rpcErr *RPCError
+	if !errors.As(plain, &rpcErr) || rpcErr.Kind != nil || rpcErr.Code != "unknown" {
+		t.Errorf("error without a status became %#v", plain)
+	}
+}
+
+func TestResponseErrorKinds(t *testing.T) {
+	tests := []struct {
+		code        string
+		description string
+		kind        error
+	}{
+		{"BAD_INPUT", "name is empty", ErrInvalidArgument},
+		{"NO_SUCH_SPACE", "", ErrSpaceNotOpen},
+		{"ACCOUNT_IS_NOT_RUNNING", "", ErrUnauthenticated},
+		{"NOT_FOUND", "", ErrNotFound},
+		{"FILE_TOO_LARGE", "", ErrQuota},
+		{"UNKNOWN_ERROR", "object Not Found in cache", ErrNotFound},
+		{"UNKNOWN_ERROR", "space not exists", ErrSpaceNotOpen},
+		{"UNKNOWN_ERROR", "files limit exceeded", ErrQuota},
+		{"UNKNOWN_ERROR", "something else", nil},
+		{"SOME_NEW_CODE", "not found", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code+"/"+tt.description, func(t *testing.T) {
+			err := responseError("ObjectCreate", responseCode(tt.code), tt.description)
+
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) || rpcErr.Code != tt.code || rpcErr.Message != tt.description {
+				t.Fatalf("got %#v", err)
+			}
+			if rpcErr.Kind != tt.kind {
+				t.Errorf("kind = %v, want %v", rpcErr.Kind, tt.kind)
+			}
+			if tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Errorf("errors.Is(err, %v) = false", tt.kind)
+			}
+			if isTransient(err) {
+				t.Error("response errors are never transient")
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/anyproto/anytype-heart/pb"
+	"github.com/gogo/protobuf/types"
+)
+
+const (
+	// eventStreamInitialBackoff is the delay before reopening a dropped event stream
+	eventStreamInitialBackoff = time.Second
+
+	// eventStreamMaxBackoff caps the delay between attempts to reopen the stream
+	eventStreamMaxBackoff = 30 * time.Second
+
+	// ownChangeWindow is how long after this process writes an object the events
+	// about it are taken to be the echo of that write rather than a remote change
+	ownChangeWindow = 5 * time.Second
+)
+
+// EventKind says what an Event reports
+type EventKind string
+
+const (
+	// EventDetailsSet replaces all details of an object
+	EventDetailsSet EventKind = "detailsSet"
+	// EventDetailsAmend changes some details of an object
+	EventDetailsAmend EventKind = "detailsAmend"
+	// EventDetailsUnset removes details of an object
+	EventDetailsUnset EventKind = "detailsUnset"
+	// EventObjectRemoved reports that an object was deleted
+	EventObjectRemoved EventKind = "objectRemoved"
+	// EventBlocksAdded reports blocks added to an object
+	EventBlocksAdded EventKind = "blocksAdded"
+	// EventBlocksDeleted reports blocks removed from an object
+	EventBlocksDeleted EventKind = "blocksDeleted"
+	// EventBlockText reports a changed text block
+	EventBlockText EventKind = "blockText"
+	// EventSpaceStatus reports a change in a space's sync status
+	EventSpaceStatus EventKind = "spaceStatus"
+)
+
+// Event is one change pushed by the server on the session event stream
+type Event struct {
+	Kind     EventKind
+	SpaceID  string
+	ObjectID string                  // object the change applies to
+	Details  map[string]*types.Value // set or amended details
+	Keys     []string                // unset detail keys
+	BlockIDs []string                // added, deleted or changed blocks
+	Text     string                  // new text of a changed text block
+	Status   string                  // space sync status, e.g. "Synced"
+	Error    string                  // space sync error, empty when there is none
+}
+
+// decodeEvent turns a server event into the events we publish. Messages of other
+// kinds are skipped.
+func decodeEvent(ev *pb.Event) []Event {
+	var events []Event
+	for _, msg := range ev.Messages {
+		base := Event{SpaceID: msg.SpaceId, ObjectID: ev.ContextId}
+
+		switch v := msg.Value.(type) {
+		case *pb.EventMessageValueOfObjectDetailsSet:
+			base.Kind = EventDetailsSet
+			base.ObjectID = v.ObjectDetailsSet.Id
+			base.Details = v.ObjectDetailsSet.GetDetails().GetFields()
+			events = append(events, base)
+
+		case *pb.EventMessageValueOfObjectDetailsAmend:
+			base.Kind = EventDetailsAmend
+			base.ObjectID = v.ObjectDetailsAmend.Id
+			base.Details = make(map[string]*types.Value, len(v.ObjectDetailsAmend.Details))
+			for _, kv := range v.ObjectDetailsAmend.Details {
+				base.Details[kv.Key] = kv.Value
+			}
+			events = append(events, base)
+
+		case *pb.EventMessageValueOfObjectDetailsUnset:
+			base.Kind = EventDetailsUnset
+			base.ObjectID = v.ObjectDetailsUnset.Id
+			base.Keys = v.ObjectDetailsUnset.Keys
+			events = append(events, base)
+
+		case *pb.EventMessageValueOfObjectRemove:
+			// One event per object, so consumers can match on ObjectID
+			for _, id := range v.ObjectRemove.Ids {
+				removed := base
+				removed.Kind = EventObjectRemoved
+				removed.ObjectID = id
+				events = append(events, removed)
+			}
+
+		case *pb.EventMessageValueOfBlockAdd:
+			base.Kind = EventBlocksAdded
+			for _, block := range v.BlockAdd.Blocks {
+				base.BlockIDs = append(base.BlockIDs, block.Id)
+			}
+			events = append(events, base)
+
+		case *pb.EventMessageValueOfBlockDelete:
+			base.Kind = EventBlocksDeleted
+			base.BlockIDs = v.BlockDelete.BlockIds
+			events = append(events, base)
+
+		case *pb.EventMessageValueOfBlockSetText:
+			base.Kind = EventBlockText
+			base.BlockIDs = []string{v.BlockSetText.Id}
+			base.Text = v.BlockSetText.GetText().GetValue()
+			events = append(events, base)
+
+		case *pb.EventMessageValueOfSpaceSyncStatusUpdate:
+			base.Kind = EventSpaceStatus
+			base.SpaceID = v.SpaceSyncStatusUpdate.Id
+			base.ObjectID = ""
+			base.Status = v.SpaceSyncStatusUpdate.Status.String()
+			if v.SpaceSyncStatusUpdate.Error != pb.EventSpace_Null {
+				base.Error = v.SpaceSyncStatusUpdate.Error.String()
+			}
+			events = append(events, base)
+		}
+	}
+	return events
+}
+
+// eventHub fans events out to subscribers
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	dropped     int // events a full subscriber channel could not take
+}
+
+// Subscribe returns a channel that receives every event from the session event
+// stream, and a function that ends the subscription and closes the channel. An
+// event is dropped for a subscriber whose buffer is full, so a slow consumer
+// cannot stall the others. Events only flow while RunEventStream runs.
+func (c *AnyTypeClient) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	c.events.mu.Lock()
+	if c.events.subscribers == nil {
+		c.events.subscribers = make(map[chan Event]struct{})
+	}
+	c.events.subscribers[ch] = struct{}{}
+	c.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			c.events.mu.Lock()
+			delete(c.events.subscribers, ch)
+			c.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish hands an event to every subscriber without blocking
+func (c *AnyTypeClient) publish(ev Event) {
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+
+	for ch := range c.events.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			c.events.dropped++
+			if c.events.dropped == 1 || c.events.dropped%100 == 0 {
+				eventsLog.Warn("Event subscriber is not keeping up", "dropped", c.events.dropped)
+			}
+		}
+	}
+}
+
+// RunEventStream keeps the session event stream open until ctx is cancelled and
+// publishes what arrives to subscribers. A dropped stream is reopened with backoff,
+// after renewing the token if the server rejected it.
+func (c *AnyTypeClient) RunEventStream(ctx context.Context) {
+	backoff := eventStreamInitialBackoff
+
+	for {
+		received, err := c.listenEvents(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = eventStreamInitialBackoff
+		}
+
+		eventsLog.Warn("Event stream closed, reopening", "backoff", backoff, "error", err)
+		if isAuthError(err) {
+			if refreshErr := c.refreshToken(ctx, c.tokens.peek()); refreshErr != nil {
+				eventsLog.Error("Token renewal failed", "error", refreshErr)
+			}
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, eventStreamMaxBackoff)
+	}
+}
+
+// listenEvents reads one stream until it ends and reports whether any event arrived
+func (c *AnyTypeClient) listenEvents(ctx context.Context) (received bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.listenEventsRPC(ctx)
+	if err != nil {
+		return false, err
+	}
+	eventsLog.Info("Listening for AnyType events")
+
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return received, fmt.Errorf("server ended the stream")
+			}
+			return received, c.handleGRPCError("ListenSessionEvents", err)
+		}
+
+		received = true
+		for _, e := range decodeEvent(ev) {
+			c.publish(e)
+		}
+	}
+}
+
+// pushLog remembers when this process last wrote each object, so the events that
+// echo its own writes are not mistaken for changes made elsewhere
+type pushLog struct {
+	mu sync.Mutex
+	at map[string]time.Time
+}
+
+// mark records a write to objectID that is about to be sent
+func (l *pushLog) mark(objectID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.at == nil {
+		l.at = make(map[string]time.Time)
+	}
+	for id, at := range l.at {
+		if now.Sub(at) >= ownChangeWindow {
+			delete(l.at, id)
+		}
+	}
+	l.at[objectID] = now
+}
+
+// recent reports whether objectID was written within ownChangeWindow
+func (l *pushLog) recent(objectID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at, ok := l.at[objectID]
+	return ok && time.Since(at) < ownChangeWindow
+}
+
+// runRemoteEvents reacts to changes made in AnyType: an object deleted there
+// loses its mapping, so the next save of its file creates a new one instead of
+// failing to update the old one, and other changes to a mapped object are
+// recorded in its file's state. Changes to objects this process has just written
+// are its own and are ignored.
+func (e *Engine) runRemoteEvents(ctx context.Context) {
+	events, unsubscribe := e.rpc.Subscribe(100)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			if ev.ObjectID == "" {
+				continue
+			}
+			state, mapped := e.objects.ByObjectID(ev.ObjectID)
+			if !mapped {
+				continue
+			}
+			if ev.Kind != EventObjectRemoved {
+				if e.pushes.recent(ev.ObjectID) {
+					continue
+				}
+				err := e.objects.Update(state.Key, func(s *FileState) {
+					s.RemoteModified = time.Now()
+				})
+				if err != nil {
+					eventsLog.Warn("Failed to save file state", "key", state.Key, "object_id", ev.ObjectID, "error", err)
+				}
+				continue
+			}
+
+			eventsLog.Warn("Object was deleted in AnyType, dropping its mapping", "key", state.Key, "object_id", ev.ObjectID)
+			err := e.objects.Delete(state.Key)
+			if err != nil {
+				eventsLog.Warn("Failed to update object mapping", "key", state.Key, "object_id", ev.ObjectID, "error", err)
+			}
+			path := state.Path
+			if path == "" {
+				path = state.Key
+			}
+			entry := AuditEntry{Path: path, Op: fileOpDelete, ObjectID: ev.ObjectID, Outcome: outcomeSuccess, Detail: "deleted in AnyType; mapping dropped"}
+			if err != nil {
+				entry.Outcome = outcomeError
+			}
+			e.audit(WithTrigger(ctx, TriggerRemote), time.Now(), entry, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HealthState is the last known health of the AnyType server
+type HealthState int
+
+const (
+	HealthUnknown HealthState = iota
+	HealthHealthy
+	HealthDegraded
+	HealthDown
+)
+
+// String names the state for logs and status
+func (s HealthState) String() string {
+	switch s {
+	case HealthHealthy:
+		return "healthy"
+	case HealthDegraded:
+		return "degraded"
+	case HealthDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// HealthMonitor tracks the outcome of periodic health checks. A failing check marks
+// the server degraded; DownAfter consecutive checks that could not reach it mark it down.
+type HealthMonitor struct {
+	mu          sync.Mutex
+	state       HealthState
+	since       time.Time // when state was entered
+	lastCheck   time.Time
+	lastErr     error
+	unreachable int // consecutive checks that could not reach the server
+}
+
+// NewHealthMonitor creates a monitor in the unknown state
+func NewHealthMonitor() *HealthMonitor {
+	return &HealthMonitor{since: time.Now()}
+}
+
+// record updates the state from a check result and returns the transition, if any
+func (h *HealthMonitor) record(err error, downAfter int) (from, to HealthState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastCheck = time.Now()
+	h.lastErr = err
+
+	from = h.state
+	switch {
+	case err == nil:
+		h.unreachable = 0
+		to = HealthHealthy
+	case isTransient(err):
+		h.unreachable++
+		to = HealthDegraded
+		if h.unreachable >= downAfter {
+			to = HealthDown
+		}
+	default:
+		h.unreachable = 0
+		to = HealthDegraded
+	}
+
+	if to != from {
+		h.state = to
+		h.since = h.lastCheck
+	}
+	return from, to
+}
+
+// State returns the current state, when it was entered and the last check error
+func (h *HealthMonitor) State() (HealthState, time.Time, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.state, h.since, h.lastErr
+}
+
+// Health returns the client's health; a nil client counts as down
+func (c *AnyTypeClient) Health() (HealthState, time.Time, error) {
+	if c == nil || c.health == nil {
+		return HealthDown, time.Time{}, fmt.Errorf("not connected")
+	}
+	return c.health.State()
+}
+
+// RunHealthMonitor runs HealthCheck every interval until ctx is cancelled, and
+// repairs what it can: it reconnects when the server is unreachable, renews the
+// token when it is rejected and reopens the space when it is not loaded.
+func (c *AnyTypeClient) RunHealthMonitor(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(c.config.Health.Interval))
+	defer ticker.Stop()
+
+	for {
+		c.checkHealth(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth runs one health check, records it and attempts recovery on failure
+func (c *AnyTypeClient) checkHealth(ctx context.Context) {
+	err := c.runHealthCheck(ctx)
+	if err != nil && ctx.Err() == nil && c.repair(ctx, err) {
+		// Check again straight away so the state reflects the repair
+		err = c.runHealthCheck(ctx)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	from, to := c.health.record(err, c.config.Health.DownAfter)
+	if from == to {
+		return
+	}
+
+	switch to {
+	case HealthHealthy:
+		healthLog.Info("AnyType healthy", "from", from, "to", to)
+		c.notifyRecovered()
+	case HealthDegraded:
+		healthLog.Warn("AnyType degraded", "from", from, "to", to, "error", err)
+	case HealthDown:
+		healthLog.Error("AnyType down", "from", from, "to", to, "error", err)
+	}
+}
+
+// runHealthCheck runs HealthCheck bounded by the configured timeout
+func (c *AnyTypeClient) runHealthCheck(ctx context.Context) error {
+	checkCtx, cancel := context.WithTimeout(ctx, time.Duration(c.config.Health.Timeout))
+	defer cancel()
+
+	return c.HealthCheck(checkCtx)
+}
+
+// repair tries the repair that matches a failed health check and reports whether it did anything
+func (c *AnyTypeClient) repair(ctx context.Context, err error) bool {
+	switch {
+	case isTransient(err):
+		// Skip gRPC's reconnect backoff so a restarted server is picked up at once
+		healthLog.Info("AnyType unreachable, reconnecting", "error", err)
+		c.conn.ResetConnectBackoff()
+		c.conn.Connect()
+		return false
+
+	case isAuthError(err):
+		healthLog.Info("Health check rejected the session token, renewing")
+		if refreshErr := c.refreshToken(ctx, c.tokens.peek()); refreshErr != nil {
+			healthLog.Error("Token renewal failed", "error", refreshErr)
+			return false
+		}
+		return true
+
+	case errors.Is(err, ErrSpaceNotOpen):
+		space := c.openedSpace()
+		if space == "" {
+			space = c.config.SpaceID
+		}
+		healthLog.Info("Space not open, reopening", "space_id", space)
+		if openErr := c.OpenSpace(ctx, space); openErr != nil {
+			healthLog.Error("Reopening space failed", "space_id", space, "error", openErr)
+			return false
+		}
+		return true
+
+	default:
+		// Permission problems and the like need an operator
+		return false
+	}
+}
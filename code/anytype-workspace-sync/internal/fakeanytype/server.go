@@ -0,0 +1,737 @@
+// Package fakeanytype is an in-memory stand-in for "anytype serve" for tests.
+//
+// It implements the part of the ClientCommands gRPC service the sync client uses
+// (sessions, WorkspaceOpen, ObjectCreate, ObjectSetDetails, ObjectListSetIsArchived,
+// ObjectListDelete, ObjectSearch, collections, FileUpload and the session event
+// stream) over a bufconn listener, and can simulate expired tokens and outages.
+package fakeanytype
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyproto/anytype-heart/pb"
+	"github.com/anyproto/anytype-heart/pb/service"
+	"github.com/anyproto/anytype-heart/pkg/lib/pb/model"
+	"github.com/gogo/protobuf/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Addr is the address to dial; any address works since DialOption ignores it
+const Addr = "bufnet"
+
+// AppKey is the app key the server exchanges for session tokens
+const AppKey = "fake-app-key"
+
+// ProfileID is the account profile returned by WorkspaceOpen
+const ProfileID = "fake-profile"
+
+// Version is returned by AppGetVersion
+const Version = "fake-0.0.0"
+
+// sessionless methods are allowed without a valid token
+var sessionless = map[string]bool{
+	"WalletCreateSession": true,
+}
+
+// layouts are the layouts of the object types the server knows; others are basic
+var layouts = map[string]model.ObjectTypeLayout{
+	"ot-note":        model.ObjectType_note,
+	"ot-page":        model.ObjectType_basic,
+	"ot-collection":  model.ObjectType_collection,
+	"ot-file":        model.ObjectType_file,
+	"ot-image":       model.ObjectType_image,
+	"ot-participant": model.ObjectType_participant,
+}
+
+// Object is a stored AnyType object
+type Object struct {
+	ID       string
+	SpaceID  string
+	Type     string // object type unique key, e.g. "ot-note"
+	Details  map[string]*types.Value
+	Archived bool
+	Members  []string // object IDs, for collections
+}
+
+// Name returns the object's name detail
+func (o Object) Name() string {
+	return o.Details["name"].GetStringValue()
+}
+
+// Description returns the object's description detail, where notes keep their content
+func (o Object) Description() string {
+	return o.Details["description"].GetStringValue()
+}
+
+// Server is an in-memory AnyType server
+type Server struct {
+	service.UnimplementedClientCommandsServer
+
+	mu      sync.Mutex
+	spaces  map[string]bool // known spaces
+	objects map[string]*Object
+	nextID  int
+	token   string // the only session token accepted
+	tokens  int    // sessions handed out so far
+	down    bool
+	refuse  bool                     // new connections fail, as if nothing listened
+	delays  map[string]time.Duration // how long calls of an RPC take
+	calls   map[string]int
+	streams map[chan *pb.Event]struct{} // open session event streams
+
+	listener *bufconn.Listener
+	grpc     *grpc.Server
+}
+
+// New starts a server that knows the given spaces. Its initial session token is
+// returned by Token.
+func New(spaceIDs ...string) *Server {
+	s := &Server{
+		spaces:   make(map[string]bool),
+		objects:  make(map[string]*Object),
+		delays:   make(map[string]time.Duration),
+		calls:    make(map[string]int),
+		streams:  make(map[chan *pb.Event]struct{}),
+		listener: bufconn.Listen(1 << 20),
+	}
+	s.token = s.newToken()
+	for _, spaceID := range spaceIDs {
+		s.AddSpace(spaceID)
+	}
+
+	s.grpc = grpc.NewServer(grpc.UnaryInterceptor(s.intercept), grpc.StreamInterceptor(s.interceptStream))
+	service.RegisterClientCommandsServer(s.grpc, s)
+	go s.grpc.Serve(s.listener)
+	return s
+}
+
+// DialOption connects a client to the server instead of the network
+func (s *Server) DialOption() grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		s.mu.Lock()
+		refuse := s.refuse
+		s.mu.Unlock()
+		if refuse {
+			return nil, fmt.Errorf("dial %s: connection refused", Addr)
+		}
+		return s.listener.DialContext(ctx)
+	})
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.grpc.Stop()
+}
+
+// AddSpace makes a space known, with this account as its owner
+func (s *Server) AddSpace(spaceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spaces[spaceID] = true
+	s.insert(spaceID, "ot-participant", map[string]*types.Value{
+		"identityProfileLink":    stringValue(ProfileID),
+		"participantPermissions": numberValue(float64(model.ParticipantPermissions_Owner)),
+	})
+}
+
+// Token returns the session token the server currently accepts
+func (s *Server) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.token
+}
+
+// ExpireToken invalidates the current session token. Calls fail with
+// Unauthenticated until the client creates a new session with AppKey.
+func (s *Server) ExpireToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = s.newToken()
+}
+
+// SetDown simulates an outage: while down every call fails with Unavailable, and
+// going down ends the open event streams
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.down = down
+	if down {
+		for ch := range s.streams {
+			close(ch)
+			delete(s.streams, ch)
+		}
+	}
+}
+
+// SetRefusing makes new connections fail as if the server was not started yet.
+// Connections already made are not affected.
+func (s *Server) SetRefusing(refuse bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refuse = refuse
+}
+
+// Refusing reports whether new connections fail
+func (s *Server) Refusing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.refuse
+}
+
+// SetDelay makes every call of rpc take d before it is handled, e.g. to catch an
+// upload in flight. A call whose client gives up ends early.
+func (s *Server) SetDelay(rpc string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delays[rpc] = d
+}
+
+// Streams returns the number of open session event streams
+func (s *Server) Streams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.streams)
+}
+
+// Emit sends an event to every open event stream, as if another client had
+// changed something
+func (s *Server) Emit(ev *pb.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.broadcast(ev)
+}
+
+// AddObject stores an object as if it was created in another client and returns its ID
+func (s *Server) AddObject(spaceID, objectType string, details map[string]*types.Value) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insert(spaceID, objectType, details).ID
+}
+
+// RemoveObject deletes an object as if it was deleted in another client
+func (s *Server) RemoveObject(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove([]string{id})
+}
+
+// Calls returns how often an RPC was called, e.g. Calls("ObjectCreate")
+func (s *Server) Calls(rpc string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[rpc]
+}
+
+// Object returns a copy of a stored object
+func (s *Server) Object(id string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.objects[id]
+	if !ok {
+		return Object{}, false
+	}
+	return obj.copy(), true
+}
+
+// Objects returns copies of every stored object of an object type, ordered by ID
+func (s *Server) Objects(objectType string) []Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var objects []Object
+	for _, obj := range s.objects {
+		if obj.Type == objectType {
+			objects = append(objects, obj.copy())
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].ID < objects[j].ID })
+	return objects
+}
+
+// intercept counts calls and injects outages and authentication failures
+func (s *Server) intercept(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	rpc := path.Base(info.FullMethod)
+
+	s.mu.Lock()
+	s.calls[rpc]++
+	down, token, delay := s.down, s.token, s.delays[rpc]
+	s.mu.Unlock()
+
+	if down {
+		return nil, status.Error(codes.Unavailable, "fake server is down")
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if !sessionless[rpc] {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if got := md.Get("token"); len(got) == 0 || got[0] != token {
+			return nil, status.Error(codes.Unauthenticated, "invalid session token")
+		}
+	}
+	return handler(ctx, req)
+}
+
+// interceptStream applies outages and token checks to streaming calls
+func (s *Server) interceptStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	rpc := path.Base(info.FullMethod)
+
+	s.mu.Lock()
+	s.calls[rpc]++
+	down, token := s.down, s.token
+	s.mu.Unlock()
+
+	if down {
+		return status.Error(codes.Unavailable, "fake server is down")
+	}
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	if got := md.Get("token"); len(got) == 0 || got[0] != token {
+		return status.Error(codes.Unauthenticated, "invalid session token")
+	}
+	return handler(srv, ss)
+}
+
+// broadcast queues an event on every open stream, skipping streams that are
+// behind; callers must hold mu
+func (s *Server) broadcast(ev *pb.Event) {
+	for ch := range s.streams {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// remove deletes objects and announces it; callers must hold mu
+func (s *Server) remove(ids []string) {
+	var removed []string
+	for _, id := range ids {
+		if _, ok := s.objects[id]; ok {
+			delete(s.objects, id)
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) > 0 {
+		s.broadcast(&pb.Event{Messages: []*pb.EventMessage{{
+			Value: &pb.EventMessageValueOfObjectRemove{ObjectRemove: &pb.EventObjectRemove{Ids: removed}},
+		}}})
+	}
+}
+
+// amended announces changed details of an object; callers must hold mu
+func (s *Server) amended(obj *Object, details []*pb.EventObjectDetailsAmendKeyValue) {
+	s.broadcast(&pb.Event{ContextId: obj.ID, Messages: []*pb.EventMessage{{
+		SpaceId: obj.SpaceID,
+		Value:   &pb.EventMessageValueOfObjectDetailsAmend{ObjectDetailsAmend: &pb.EventObjectDetailsAmend{Id: obj.ID, Details: details}},
+	}}})
+}
+
+// newToken makes up a session token; callers must hold mu or own s
+func (s *Server) newToken() string {
+	s.tokens++
+	return fmt.Sprintf("fake-token-%d", s.tokens)
+}
+
+// insert stores a new object; callers must hold mu
+func (s *Server) insert(spaceID, objectType string, details map[string]*types.Value) *Object {
+	s.nextID++
+	obj := &Object{
+		ID:      fmt.Sprintf("fake-object-%d", s.nextID),
+		SpaceID: spaceID,
+		Type:    objectType,
+		Details: make(map[string]*types.Value, len(details)+1),
+	}
+	for key, value := range details {
+		obj.Details[key] = value
+	}
+	obj.Details["createdDate"] = numberValue(float64(time.Now().Unix()))
+	s.objects[obj.ID] = obj
+	return obj
+}
+
+// copy returns a copy that does not share maps or slices with o
+func (o *Object) copy() Object {
+	c := *o
+	c.Details = make(map[string]*types.Value, len(o.Details))
+	for key, value := range o.Details {
+		c.Details[key] = value
+	}
+	c.Members = append([]string(nil), o.Members...)
+	return c
+}
+
+// AppGetVersion reports the fake version
+func (s *Server) AppGetVersion(ctx context.Context, req *pb.RpcAppGetVersionRequest) *pb.RpcAppGetVersionResponse {
+	return &pb.RpcAppGetVersionResponse{Version: Version}
+}
+
+// WalletCreateSession exchanges AppKey for a new session token, which replaces the old one
+func (s *Server) WalletCreateSession(ctx context.Context, req *pb.RpcWalletCreateSessionRequest) *pb.RpcWalletCreateSessionResponse {
+	if req.GetAppKey() != AppKey {
+		return &pb.RpcWalletCreateSessionResponse{Error: &pb.RpcWalletCreateSessionResponseError{
+			Code:        pb.RpcWalletCreateSessionResponseError_APP_TOKEN_NOT_FOUND_IN_THE_CURRENT_ACCOUNT,
+			Description: "unknown app key",
+		}}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = s.newToken()
+	return &pb.RpcWalletCreateSessionResponse{Token: s.token}
+}
+
+// WorkspaceOpen opens a known space
+func (s *Server) WorkspaceOpen(ctx context.Context, req *pb.RpcWorkspaceOpenRequest) *pb.RpcWorkspaceOpenResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.spaces[req.SpaceId] {
+		return &pb.RpcWorkspaceOpenResponse{Error: &pb.RpcWorkspaceOpenResponseError{
+			Code:        pb.RpcWorkspaceOpenResponseError_FAILED_TO_LOAD,
+			Description: "space not exists",
+		}}
+	}
+	return &pb.RpcWorkspaceOpenResponse{Info: &model.AccountInfo{AccountSpaceId: req.SpaceId, ProfileObjectId: ProfileID}}
+}
+
+// ObjectCreate stores a new object with the given details
+func (s *Server) ObjectCreate(ctx context.Context, req *pb.RpcObjectCreateRequest) *pb.RpcObjectCreateResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.spaces[req.SpaceId] {
+		return &pb.RpcObjectCreateResponse{Error: &pb.RpcObjectCreateResponseError{
+			Code:        pb.RpcObjectCreateResponseError_UNKNOWN_ERROR,
+			Description: "space not exists",
+		}}
+	}
+
+	obj := s.insert(req.SpaceId, req.ObjectTypeUniqueKey, req.GetDetails().GetFields())
+	return &pb.RpcObjectCreateResponse{ObjectId: obj.ID}
+}
+
+// ObjectSetDetails replaces details of an existing object
+func (s *Server) ObjectSetDetails(ctx context.Context, req *pb.RpcObjectSetDetailsRequest) *pb.RpcObjectSetDetailsResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.objects[req.ContextId]
+	if !ok {
+		return &pb.RpcObjectSetDetailsResponse{Error: &pb.RpcObjectSetDetailsResponseError{
+			Code:        pb.RpcObjectSetDetailsResponseError_UNKNOWN_ERROR,
+			Description: "object not found",
+		}}
+	}
+
+	var amended []*pb.EventObjectDetailsAmendKeyValue
+	for _, detail := range req.Details {
+		obj.Details[detail.Key] = detail.Value
+		amended = append(amended, &pb.EventObjectDetailsAmendKeyValue{Key: detail.Key, Value: detail.Value})
+	}
+	s.amended(obj, amended)
+	return &pb.RpcObjectSetDetailsResponse{}
+}
+
+// ObjectListSetIsArchived moves objects to or out of the bin
+func (s *Server) ObjectListSetIsArchived(ctx context.Context, req *pb.RpcObjectListSetIsArchivedRequest) *pb.RpcObjectListSetIsArchivedResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range req.ObjectIds {
+		if _, ok := s.objects[id]; !ok {
+			return &pb.RpcObjectListSetIsArchivedResponse{Error: &pb.RpcObjectListSetIsArchivedResponseError{
+				Code:        pb.RpcObjectListSetIsArchivedResponseError_UNKNOWN_ERROR,
+				Description: "object not found",
+			}}
+		}
+	}
+	for _, id := range req.ObjectIds {
+		s.objects[id].Archived = req.IsArchived
+		s.amended(s.objects[id], []*pb.EventObjectDetailsAmendKeyValue{{Key: "isArchived", Value: boolValue(req.IsArchived)}})
+	}
+	return &pb.RpcObjectListSetIsArchivedResponse{}
+}
+
+// ObjectListDelete removes objects for good; unknown IDs are ignored
+func (s *Server) ObjectListDelete(ctx context.Context, req *pb.RpcObjectListDeleteRequest) *pb.RpcObjectListDeleteResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(req.ObjectIds)
+	return &pb.RpcObjectListDeleteResponse{}
+}
+
+// ObjectCollectionAdd adds objects to a collection
+func (s *Server) ObjectCollectionAdd(ctx context.Context, req *pb.RpcObjectCollectionAddRequest) *pb.RpcObjectCollectionAddResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	collection, ok := s.objects[req.ContextId]
+	if !ok || collection.Type != "ot-collection" {
+		return &pb.RpcObjectCollectionAddResponse{Error: &pb.RpcObjectCollectionAddResponseError{
+			Code:        pb.RpcObjectCollectionAddResponseError_UNKNOWN_ERROR,
+			Description: "collection not found",
+		}}
+	}
+
+	for _, id := range req.ObjectIds {
+		if !contains(collection.Members, id) {
+			collection.Members = append(collection.Members, id)
+		}
+	}
+	return &pb.RpcObjectCollectionAddResponse{}
+}
+
+// ObjectCollectionRemove takes objects out of a collection
+func (s *Server) ObjectCollectionRemove(ctx context.Context, req *pb.RpcObjectCollectionRemoveRequest) *pb.RpcObjectCollectionRemoveResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	collection, ok := s.objects[req.ContextId]
+	if !ok || collection.Type != "ot-collection" {
+		return &pb.RpcObjectCollectionRemoveResponse{Error: &pb.RpcObjectCollectionRemoveResponseError{
+			Code:        pb.RpcObjectCollectionRemoveResponseError_UNKNOWN_ERROR,
+			Description: "collection not found",
+		}}
+	}
+
+	members := collection.Members[:0]
+	for _, id := range collection.Members {
+		if !contains(req.ObjectIds, id) {
+			members = append(members, id)
+		}
+	}
+	collection.Members = members
+	return &pb.RpcObjectCollectionRemoveResponse{}
+}
+
+// FileUpload stores a file object for a local file, which must exist
+func (s *Server) FileUpload(ctx context.Context, req *pb.RpcFileUploadRequest) *pb.RpcFileUploadResponse {
+	if _, err := os.Stat(req.LocalPath); err != nil {
+		return &pb.RpcFileUploadResponse{Error: &pb.RpcFileUploadResponseError{
+			Code:        pb.RpcFileUploadResponseError_BAD_INPUT,
+			Description: err.Error(),
+		}}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objectType := "ot-file"
+	if req.Type == model.BlockContentFile_Image {
+		objectType = "ot-image"
+	}
+
+	details := map[string]*types.Value{
+		"name": stringValue(strings.TrimSuffix(filepath.Base(req.LocalPath), filepath.Ext(req.LocalPath))),
+	}
+	for key, value := range req.GetDetails().GetFields() {
+		details[key] = value
+	}
+
+	obj := s.insert(req.SpaceId, objectType, details)
+	return &pb.RpcFileUploadResponse{ObjectId: obj.ID}
+}
+
+// ListenSessionEvents streams the events of every change until the client goes
+// away or the server goes down
+func (s *Server) ListenSessionEvents(req *pb.StreamRequest, srv service.ClientCommands_ListenSessionEventsServer) {
+	ch := make(chan *pb.Event, 100)
+
+	s.mu.Lock()
+	s.streams[ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, ch)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-srv.Context().Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := srv.Send(ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ObjectSearch returns the objects of a space matching every filter. Like the real
+// server, archived objects are left out unless a filter mentions isArchived.
+func (s *Server) ObjectSearch(ctx context.Context, req *pb.RpcObjectSearchRequest) *pb.RpcObjectSearchResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range req.Filters {
+		switch f.Condition {
+		case model.BlockContentDataviewFilter_Equal, model.BlockContentDataviewFilter_Like, model.BlockContentDataviewFilter_In:
+		default:
+			return &pb.RpcObjectSearchResponse{Error: &pb.RpcObjectSearchResponseError{
+				Code:        pb.RpcObjectSearchResponseError_BAD_INPUT,
+				Description: fmt.Sprintf("fake server does not support filter condition %s", f.Condition),
+			}}
+		}
+	}
+
+	ids := make([]string, 0, len(s.objects))
+	for id := range s.objects {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var records []*types.Struct
+	for _, id := range ids {
+		obj := s.objects[id]
+		if obj.SpaceID != req.SpaceId || !obj.matches(req.Filters) {
+			continue
+		}
+		records = append(records, obj.record(req.Keys))
+		if req.Limit > 0 && len(records) == int(req.Limit) {
+			break
+		}
+	}
+	return &pb.RpcObjectSearchResponse{Records: records}
+}
+
+// matches reports whether the object passes every filter
+func (o *Object) matches(filters []*model.BlockContentDataviewFilter) bool {
+	archivedFiltered := false
+	for _, f := range filters {
+		if f.RelationKey == "isArchived" {
+			archivedFiltered = true
+		}
+
+		value := o.detail(f.RelationKey)
+		switch f.Condition {
+		case model.BlockContentDataviewFilter_Equal:
+			if !equal(value, f.Value) {
+				return false
+			}
+		case model.BlockContentDataviewFilter_Like:
+			if !strings.Contains(strings.ToLower(value.GetStringValue()), strings.ToLower(f.Value.GetStringValue())) {
+				return false
+			}
+		case model.BlockContentDataviewFilter_In:
+			found := false
+			for _, candidate := range f.Value.GetListValue().GetValues() {
+				if equal(value, candidate) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return archivedFiltered || !o.Archived
+}
+
+// detail returns a detail, including the ones the server derives itself
+func (o *Object) detail(key string) *types.Value {
+	switch key {
+	case "id":
+		return stringValue(o.ID)
+	case "isArchived":
+		return boolValue(o.Archived)
+	case "isDeleted":
+		return boolValue(false)
+	case "layout":
+		return numberValue(float64(layouts[o.Type]))
+	}
+	return o.Details[key]
+}
+
+// record returns the requested details of the object; no keys means all of them
+func (o *Object) record(keys []string) *types.Struct {
+	if len(keys) == 0 {
+		keys = []string{"id", "isArchived"}
+		for key := range o.Details {
+			keys = append(keys, key)
+		}
+	}
+
+	fields := make(map[string]*types.Value, len(keys))
+	for _, key := range keys {
+		if value := o.detail(key); value != nil {
+			fields[key] = value
+		}
+	}
+	return &types.Struct{Fields: fields}
+}
+
+// equal compares two scalar values
+func equal(a, b *types.Value) bool {
+	switch b.GetKind().(type) {
+	case *types.Value_StringValue:
+		return a.GetStringValue() == b.GetStringValue()
+	case *types.Value_NumberValue:
+		return a.GetNumberValue() == b.GetNumberValue()
+	case *types.Value_BoolValue:
+		return a.GetBoolValue() == b.GetBoolValue()
+	}
+	return false
+}
+
+// contains reports whether ids holds id
+func contains(ids []string, id string) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// stringValue wraps a string in a protobuf value
+func stringValue(s string) *types.Value {
+	return &types.Value{Kind: &types.Value_StringValue{StringValue: s}}
+}
+
+// numberValue wraps a number in a protobuf value
+func numberValue(n float64) *types.Value {
+	return &types.Value{Kind: &types.Value_NumberValue{NumberValue: n}}
+}
+
+// boolValue wraps a bool in a protobuf value
+func boolValue(b bool) *types.Value {
+	return &types.Value{Kind: &types.Value_BoolValue{BoolValue: b}}
+}
@@ -0,0 +1,236 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Subsystems, each of which can have its own log level
+const (
+	subsystemMain       = "main"
+	subsystemAPI        = "api"
+	subsystemClient     = "client"
+	subsystemAuth       = "auth"
+	subsystemEngine     = "engine"
+	subsystemObjectMap  = "objectmap"
+	subsystemQueue      = "queue"
+	subsystemHealth     = "health"
+	subsystemEvents     = "events"
+	subsystemDelete     = "delete"
+	subsystemSupervisor = "supervisor"
+	subsystemStatus     = "status"
+)
+
+// subsystems lists the names accepted in log.levels
+var subsystems = []string{
+	subsystemMain, subsystemAPI, subsystemClient, subsystemAuth, subsystemEngine, subsystemObjectMap,
+	subsystemQueue, subsystemHealth, subsystemEvents, subsystemDelete, subsystemSupervisor, subsystemStatus,
+}
+
+// Loggers of the subsystems
+var (
+	apiLog        = newLogger(subsystemAPI)
+	clientLog     = newLogger(subsystemClient)
+	authLog       = newLogger(subsystemAuth)
+	engineLog     = newLogger(subsystemEngine)
+	objectMapLog  = newLogger(subsystemObjectMap)
+	queueLog      = newLogger(subsystemQueue)
+	healthLog     = newLogger(subsystemHealth)
+	eventsLog     = newLogger(subsystemEvents)
+	deleteLog     = newLogger(subsystemDelete)
+	supervisorLog = newLogger(subsystemSupervisor)
+	statusLog     = newLogger(subsystemStatus)
+)
+
+// LogConfig controls the service's log output
+type LogConfig struct {
+	// Format is "text" (key=value pairs) or "json" (one object per line)
+	Format string `json:"format"`
+	// Level is the minimum level logged: "debug", "info", "warn" or "error"
+	Level string `json:"level"`
+	// Levels overrides Level per subsystem, e.g. {"api": "debug"}
+	Levels map[string]string `json:"levels"`
+}
+
+// validate rejects unknown formats, levels and subsystems
+func (c LogConfig) validate() error {
+	if c.Format != LogFormatText && c.Format != LogFormatJSON {
+		return fmt.Errorf("log.format must be %q or %q", LogFormatText, LogFormatJSON)
+	}
+	if _, err := parseLevel(c.Level); err != nil {
+		return fmt.Errorf("log.level: %w", err)
+	}
+	for subsystem, level := range c.Levels {
+		if !knownSubsystem(subsystem) {
+			return fmt.Errorf("log.levels: unknown subsystem %q (known: %s)", subsystem, strings.Join(subsystems, ", "))
+		}
+		if _, err := parseLevel(level); err != nil {
+			return fmt.Errorf("log.levels.%s: %w", subsystem, err)
+		}
+	}
+	return nil
+}
+
+// parseLevel reads a level name such as "info" or "debug"
+func parseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown level %q", name)
+	}
+	return level, nil
+}
+
+// knownSubsystem reports whether name is one of the subsystems
+func knownSubsystem(name string) bool {
+	for _, s := range subsystems {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// logState is the active log configuration. It is swapped as a whole, so the
+// package-level loggers pick up SetupLogging without being recreated.
+type logState struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+// levelOf returns the minimum level logged for subsystem
+func (s *logState) levelOf(subsystem string) slog.Level {
+	if level, ok := s.levels[subsystem]; ok {
+		return level
+	}
+	return s.level
+}
+
+// activeLog holds the current *logState; text at info level on stdout until SetupLogging runs
+var activeLog atomic.Pointer[logState]
+
+func init() {
+	activeLog.Store(&logState{
+		handler: slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		level:   slog.LevelInfo,
+	})
+}
+
+// SetupLogging switches all logging of the package, and slog's default logger, to
+// the configured format and levels, writing to w
+func SetupLogging(config LogConfig, w io.Writer) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	state := &logState{levels: make(map[string]slog.Level, len(config.Levels))}
+	if config.Format == LogFormatJSON {
+		state.handler = slog.NewJSONHandler(w, opts)
+	} else {
+		state.handler = slog.NewTextHandler(w, opts)
+	}
+	state.level, _ = parseLevel(config.Level)
+	for subsystem, name := range config.Levels {
+		state.levels[subsystem], _ = parseLevel(name)
+	}
+	activeLog.Store(state)
+
+	slog.SetDefault(Logger(subsystemMain))
+	return nil
+}
+
+// Logger returns the logger of a subsystem, for programs that embed the package
+// and want their own lines to follow its format and levels
+func Logger(subsystem string) *slog.Logger {
+	return newLogger(subsystem)
+}
+
+// newLogger returns a logger that tags its records with subsystem
+func newLogger(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{subsystem: subsystem})
+}
+
+// subsystemHandler filters records by its subsystem's level and hands them to the
+// active handler. Attributes and groups added with With are replayed onto it.
+type subsystemHandler struct {
+	subsystem string
+	wrap      []func(slog.Handler) slog.Handler
+}
+
+// Enabled applies the subsystem's level
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= activeLog.Load().levelOf(h.subsystem)
+}
+
+// Handle adds the subsystem and, for AnyType errors, the error code
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	var code string
+	r.Attrs(func(a slog.Attr) bool {
+		if err, ok := a.Value.Any().(error); ok && a.Key == "error" {
+			var rpcErr *RPCError
+			if errors.As(err, &rpcErr) {
+				code = rpcErr.Code
+			}
+			return false
+		}
+		return true
+	})
+	if code != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("error_code", code))
+	}
+
+	handler := activeLog.Load().handler.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler that adds attrs to every record
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+// WithGroup returns a handler that nests later attributes under name
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+// with returns a copy of h with one more wrapper
+func (h *subsystemHandler) with(wrap func(slog.Handler) slog.Handler) slog.Handler {
+	wraps := append(append([]func(slog.Handler) slog.Handler(nil), h.wrap...), wrap)
+	return &subsystemHandler{subsystem: h.subsystem, wrap: wraps}
+}
+
+// logInterceptor logs every attempt of a ClientCommands call with its duration at debug level
+func logInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err != nil {
+			apiLog.Debug("RPC failed", "rpc", path.Base(method), "duration", time.Since(start), "error_code", status.Code(err).String())
+		} else {
+			apiLog.Debug("RPC finished", "rpc", path.Base(method), "duration", time.Since(start))
+		}
+		return err
+	}
+}
@@ -0,0 +1,143 @@
+package anytypesync
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maintenanceCheckInterval is how often the schedule is checked for a window
+// starting or ending
+const maintenanceCheckInterval = time.Second
+
+// weekdays are the names maintenance windows use for days of the week
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// activeAt returns when the window that covers t ends; a window may start on the
+// previous day and run past midnight
+func (w MaintenanceWindow) activeAt(t time.Time, loc *time.Location) (time.Time, bool) {
+	clock, err := parseClock(w.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	t = t.In(loc)
+	for _, back := range []int{0, -1} {
+		day := t.AddDate(0, 0, back)
+		if !w.on(day) {
+			continue
+		}
+		y, m, d := day.Date()
+		start := time.Date(y, m, d, clock.Hour(), clock.Minute(), clock.Second(), 0, loc)
+		end := start.Add(time.Duration(w.Duration))
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// on reports whether the window starts on day
+func (w MaintenanceWindow) on(day time.Time) bool {
+	if w.Date != "" {
+		return day.Format(time.DateOnly) == w.Date
+	}
+	return len(w.Days) == 0 || slices.Contains(w.Days, weekdays[day.Weekday()])
+}
+
+// parseClock reads a window start, HH:MM or HH:MM:SS
+func parseClock(value string) (time.Time, error) {
+	if t, err := time.Parse("15:04", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.TimeOnly, value)
+}
+
+// location returns the zone window times are in
+func (c MaintenanceConfig) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// activeAt returns when the maintenance covering t ends, taking the latest end
+// of overlapping windows
+func (c MaintenanceConfig) activeAt(t time.Time) (time.Time, bool) {
+	loc := c.location()
+	var until time.Time
+	for _, w := range c.Windows {
+		if end, ok := w.activeAt(t, loc); ok && end.After(until) {
+			until = end
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// validate checks the windows can be scheduled
+func (c MaintenanceConfig) validate() error {
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return fmt.Errorf("maintenance.timezone: %w", err)
+		}
+	}
+	for i, w := range c.Windows {
+		if _, err := parseClock(w.Start); err != nil {
+			return fmt.Errorf("maintenance.windows[%d].start must be HH:MM or HH:MM:SS", i)
+		}
+		if w.Duration <= 0 || time.Duration(w.Duration) > 24*time.Hour {
+			return fmt.Errorf("maintenance.windows[%d].duration must be positive and at most 24h", i)
+		}
+		if w.Date != "" {
+			if _, err := time.Parse(time.DateOnly, w.Date); err != nil {
+				return fmt.Errorf("maintenance.windows[%d].date must be YYYY-MM-DD", i)
+			}
+		}
+		for _, day := range w.Days {
+			if !slices.Contains(weekdays, day) {
+				return fmt.Errorf("maintenance.windows[%d].days: unknown day %q, use %s", i, day, strings.Join(weekdays, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// checkMaintenance pauses syncing when a maintenance window starts and resumes
+// it when the window ends. A window ended early by Resume stays ended.
+func (e *Engine) checkMaintenance(now time.Time) {
+	until, active := e.config.Maintenance.activeAt(now)
+	e.setPause(func(p *pauseState) {
+		switch {
+		case active && now.Before(p.skipUntil):
+		case active:
+			if p.maintenance.IsZero() {
+				engineLog.Info("Maintenance window started", "until", until)
+			}
+			p.maintenance = until
+		case !p.maintenance.IsZero():
+			engineLog.Info("Maintenance window ended")
+			p.maintenance = time.Time{}
+		}
+	})
+}
+
+// runMaintenance follows the maintenance schedule until ctx is cancelled
+func (e *Engine) runMaintenance(ctx context.Context) {
+	ticker := time.NewTicker(maintenanceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.checkMaintenance(now)
+		}
+	}
+}
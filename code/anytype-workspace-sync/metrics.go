@@ -0,0 +1,142 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Values of the op label of anytype_sync_files_total
+const (
+	fileOpCreate = "create"
+	fileOpUpdate = "update"
+	fileOpDelete = "delete"
+)
+
+// Values of the outcome labels
+const (
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+	outcomeQueued  = "queued"
+	outcomeHeld    = "held"
+)
+
+// metricsRegistry holds every metric of the package, apart from the default
+// registry so embedding programs keep control of theirs
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	filesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "anytype_sync_files_total",
+		Help: "Files created, updated and deleted in AnyType, by operation, file type and outcome.",
+	}, []string{"op", "type", "outcome"})
+
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "anytype_sync_rpc_duration_seconds",
+		Help:    "Latency of each AnyType RPC attempt, by method and gRPC status code.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"method", "code"})
+
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "anytype_sync_queue_depth",
+		Help: "Operations waiting for AnyType to become reachable.",
+	})
+
+	tokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "anytype_sync_token_refreshes_total",
+		Help: "Session token renewals, by outcome.",
+	}, []string{"outcome"})
+
+	watcherErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "anytype_sync_watcher_errors_total",
+		Help: "Errors reported by fsnotify, by watcher (workspace or token).",
+	}, []string{"watcher"})
+
+	lastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "anytype_sync_last_success_timestamp_seconds",
+		Help: "Unix time of the last file successfully synced or removed.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		filesTotal, rpcDuration, queueDepth, tokenRefreshes, watcherErrors, lastSuccess,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// MetricsHandler serves the package's metrics in the Prometheus text format, for
+// programs that embed the engine and run their own HTTP server
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// fileType returns the type label of a file: "markdown" or "binary"
+func fileType(filePath string) string {
+	if strings.EqualFold(filepath.Ext(filePath), ".md") {
+		return "markdown"
+	}
+	return "binary"
+}
+
+// countFile records one file operation and, on success, the time of it
+func countFile(op, filePath, outcome string) {
+	filesTotal.WithLabelValues(op, fileType(filePath), outcome).Inc()
+	if outcome == outcomeSuccess {
+		lastSuccess.SetToCurrentTime()
+	}
+}
+
+// countRenewal records the outcome of a token renewal
+func countRenewal(err error) {
+	if err != nil {
+		tokenRefreshes.WithLabelValues(outcomeError).Inc()
+	} else {
+		tokenRefreshes.WithLabelValues(outcomeSuccess).Inc()
+	}
+}
+
+// metricsInterceptor records the latency of every attempt of a ClientCommands call
+func metricsInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		rpcDuration.WithLabelValues(path.Base(method), status.Code(err).String()).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// serveMetrics serves /metrics on config.Metrics.Addr until ctx is cancelled. A
+// failure to listen is logged; syncing goes on without metrics.
+func (e *Engine) serveMetrics(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle(e.config.Metrics.Path, MetricsHandler())
+	server := &http.Server{
+		Addr:              e.config.Metrics.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	engineLog.Info("Serving metrics", "addr", server.Addr, "path", e.config.Metrics.Path)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		engineLog.Error("Metrics server failed", "addr", server.Addr, "error", err)
+	}
+}
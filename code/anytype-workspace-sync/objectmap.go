@@ -1,87 +1,580 @@
-package main
+package anytypesync
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+const (
+	// stateDBFile is the sync state database file name under the state directory
+	stateDBFile = ".anytype-workspace-state.db"
+
+	// stateDBVersion is the schema version of the state database
+	stateDBVersion = 1
+
+	// objectMapFile is the JSON object map kept before the state database; it is
+	// migrated on first start
+	objectMapFile = ".anytype-workspace-objectmap.json"
+
+	// objectMapVersion is the newest schema version of the JSON object map
+	objectMapVersion = 1
+
+	// stateBackups is how many backups are kept, as path.1 (newest) ... path.N
+	stateBackups = 5
+
+	// stateBackupInterval is how often a change also refreshes the backups
+	stateBackupInterval = time.Hour
+
+	// stateOpenTimeout bounds the wait for another process holding the database
+	stateOpenTimeout = 2 * time.Second
 )
 
-const objectMapFile = "/root/.anytype-workspace-objectmap.json"
+// State database buckets
+var (
+	bucketFiles   = []byte("files")   // key -> FileState as JSON
+	bucketPaths   = []byte("paths")   // path -> key
+	bucketObjects = []byte("objects") // object ID -> key
+	bucketMeta    = []byte("meta")
+	metaVersion   = []byte("version")
+)
 
-// ObjectMap tracks the mapping between filenames and AnyType object IDs
+// errStateCorrupt marks a state database that is damaged, as opposed to one that
+// can't be opened for another reason, such as permissions or a full disk
+var errStateCorrupt = errors.New("state database is corrupted")
+
+// FileState is what the engine knows about a synced file
+type FileState struct {
+	Key            string    `json:"key"` // path relative to the workspace
+	Path           string    `json:"path,omitempty"`
+	ObjectID       string    `json:"objectId"`
+	SpaceID        string    `json:"spaceId,omitempty"`
+	Type           string    `json:"type,omitempty"`          // markdown or binary
+	Hash           string    `json:"hash,omitempty"`          // SHA-256 of the content last synced
+	ModTime        time.Time `json:"modTime,omitzero"`        // of the file when it was last synced
+	SyncedAt       time.Time `json:"syncedAt,omitzero"`       // last successful sync
+	RemoteModified time.Time `json:"remoteModified,omitzero"` // last change seen from AnyType
+	AssetIDs       []string  `json:"assetIds,omitempty"`      // file objects the object embeds
+	LastError      string    `json:"lastError,omitempty"`     // of the last failed sync
+	LastErrorAt    time.Time `json:"lastErrorAt,omitzero"`
+}
+
+// objectMapData is the JSON object map file
+type objectMapData struct {
+	Version  int               `json:"version"`
+	Mappings map[string]string `json:"mappings"`
+}
+
+// ObjectMap keeps the sync state of every file in an embedded database, looked
+// up by object map key (the path relative to the workspace), by path or by object ID
 type ObjectMap struct {
-	mu      sync.RWMutex
-	mapping map[string]string // filename -> objectID
+	path string
+	db   *bolt.DB
+
+	backupMu   sync.Mutex
+	lastBackup time.Time
 }
 
-// NewObjectMap creates a new object map and loads existing mappings
-func NewObjectMap() (*ObjectMap, error) {
-	om := &ObjectMap{
-		mapping: make(map[string]string),
+// NewObjectMap opens the state database at path, migrating a JSON object map
+// found next to it. A corrupted database is replaced by the newest readable backup;
+// any other failure to open it is returned, so a backup never hides a fixable problem.
+func NewObjectMap(path string) (*ObjectMap, error) {
+	om := &ObjectMap{path: path}
+	if info, err := os.Stat(om.backupPath(1)); err == nil {
+		om.lastBackup = info.ModTime()
 	}
 
-	// Try to load existing mappings
-	if err := om.load(); err != nil {
-		// If file doesn't exist, that's ok
-		if !os.IsNotExist(err) {
-			return nil, fmt.Errorf("failed to load object map: %w", err)
-		}
+	db, err := openStateDB(path)
+	if errors.Is(err, berrors.ErrTimeout) {
+		return nil, fmt.Errorf("state database %s is in use by another process (is the service running?)", path)
+	}
+	if errors.Is(err, errStateCorrupt) {
+		db, err = om.recover(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state database: %w", err)
+	}
+	om.db = db
+
+	if err := om.migrate(filepath.Join(filepath.Dir(path), objectMapFile)); err != nil {
+		db.Close()
+		return nil, err
 	}
 
+	objectMapLog.Debug("State database opened", "path", path, "mappings", om.Len())
 	return om, nil
 }
 
-// Set stores a filename -> objectID mapping
+// Close closes the database
+func (om *ObjectMap) Close() error {
+	return om.db.Close()
+}
+
+// Set stores a filename -> objectID mapping. A file mapped to another object
+// starts over with a fresh state.
 func (om *ObjectMap) Set(filename, objectID string) error {
-	om.mu.Lock()
-	defer om.mu.Unlock()
+	objectMapLog.Debug("Mapping set", "key", filename, "object_id", objectID)
+	return om.Update(filename, func(s *FileState) {
+		if s.ObjectID != objectID {
+			*s = FileState{Key: filename, Path: s.Path, ObjectID: objectID}
+		}
+	})
+}
 
-	om.mapping[filename] = objectID
-	return om.save()
+// Update changes the state of a file, creating it when the file has none.
+// Concurrent updates are written together in one transaction.
+func (om *ObjectMap) Update(filename string, fn func(*FileState)) error {
+	err := om.db.Batch(func(tx *bolt.Tx) error {
+		old, _, err := getState(tx, filename)
+		if err != nil {
+			return err
+		}
+		state := old
+		state.AssetIDs = append([]string(nil), old.AssetIDs...)
+		fn(&state)
+		state.Key = filename
+		return putState(tx, old, state)
+	})
+	if err != nil {
+		objectMapLog.Error("Failed to save file state", "key", filename, "error", err)
+		return err
+	}
+	om.backupIfDue()
+	return nil
 }
 
 // Get retrieves the objectID for a filename
 func (om *ObjectMap) Get(filename string) (string, bool) {
-	om.mu.RLock()
-	defer om.mu.RUnlock()
+	state, ok := om.State(filename)
+	return state.ObjectID, ok
+}
+
+// State returns the sync state of a file by object map key
+func (om *ObjectMap) State(filename string) (FileState, bool) {
+	var state FileState
+	var found bool
+	om.db.View(func(tx *bolt.Tx) error {
+		var err error
+		state, found, err = getState(tx, filename)
+		return err
+	})
+	return state, found && state.ObjectID != ""
+}
+
+// ByPath returns the sync state of the file at path
+func (om *ObjectMap) ByPath(path string) (FileState, bool) {
+	return om.lookup(bucketPaths, path)
+}
+
+// ByObjectID returns the sync state of the file mapped to an object
+func (om *ObjectMap) ByObjectID(objectID string) (FileState, bool) {
+	return om.lookup(bucketObjects, objectID)
+}
 
-	objectID, exists := om.mapping[filename]
-	return objectID, exists
+// lookup finds a file's state through an index bucket
+func (om *ObjectMap) lookup(index []byte, value string) (FileState, bool) {
+	var state FileState
+	var found bool
+	om.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket(index).Get([]byte(value))
+		if key == nil {
+			return nil
+		}
+		var err error
+		state, found, err = getState(tx, string(key))
+		return err
+	})
+	return state, found
+}
+
+// States returns the state of every file, ordered by key
+func (om *ObjectMap) States() []FileState {
+	var states []FileState
+	om.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketFiles).ForEach(func(_, data []byte) error {
+			var state FileState
+			if err := json.Unmarshal(data, &state); err == nil && state.ObjectID != "" {
+				states = append(states, state)
+			}
+			return nil
+		})
+	})
+	return states
+}
+
+// Entries returns a snapshot of all filename -> objectID mappings
+func (om *ObjectMap) Entries() map[string]string {
+	states := om.States()
+	entries := make(map[string]string, len(states))
+	for _, state := range states {
+		entries[state.Key] = state.ObjectID
+	}
+	return entries
+}
+
+// Len returns the number of mapped files. Files sharing an object each count.
+func (om *ObjectMap) Len() int {
+	n := 0
+	om.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucketFiles).Stats().KeyN
+		return nil
+	})
+	return n
 }
 
 // Delete removes a filename mapping
 func (om *ObjectMap) Delete(filename string) error {
-	om.mu.Lock()
-	defer om.mu.Unlock()
+	err := om.db.Batch(func(tx *bolt.Tx) error {
+		old, found, err := getState(tx, filename)
+		if err != nil || !found {
+			return err
+		}
+		objectMapLog.Debug("Mapping removed", "key", filename, "object_id", old.ObjectID)
+		unindex(tx.Bucket(bucketPaths), old.Path, filename)
+		unindex(tx.Bucket(bucketObjects), old.ObjectID, filename)
+		return tx.Bucket(bucketFiles).Delete([]byte(filename))
+	})
+	if err != nil {
+		objectMapLog.Error("Failed to save file state", "key", filename, "error", err)
+		return err
+	}
+	om.backupIfDue()
+	return nil
+}
+
+// Rekey moves the state of a file from one key to another, keeping everything
+// recorded about it. It does nothing when to already has a state.
+func (om *ObjectMap) Rekey(from, to string) error {
+	err := om.db.Batch(func(tx *bolt.Tx) error {
+		state, found, err := getState(tx, from)
+		if err != nil || !found {
+			return err
+		}
+		if _, taken, err := getState(tx, to); err != nil || taken {
+			return err
+		}
+		unindex(tx.Bucket(bucketPaths), state.Path, from)
+		unindex(tx.Bucket(bucketObjects), state.ObjectID, from)
+		if err := tx.Bucket(bucketFiles).Delete([]byte(from)); err != nil {
+			return err
+		}
+		state.Key = to
+		return putState(tx, FileState{}, state)
+	})
+	if err != nil {
+		objectMapLog.Error("Failed to save file state", "key", from, "error", err)
+		return err
+	}
+	om.backupIfDue()
+	return nil
+}
+
+// Flush makes sure every change is on disk. Each transaction is synced as it
+// commits, so this only matters when the database runs without syncing.
+func (om *ObjectMap) Flush() error {
+	return om.db.Sync()
+}
 
-	delete(om.mapping, filename)
-	return om.save()
+// getState reads a file's state in tx
+func getState(tx *bolt.Tx, key string) (FileState, bool, error) {
+	data := tx.Bucket(bucketFiles).Get([]byte(key))
+	if data == nil {
+		return FileState{}, false, nil
+	}
+	var state FileState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return FileState{}, false, fmt.Errorf("state of %s: %w", key, err)
+	}
+	return state, true, nil
 }
 
-// load reads the object map from disk
-func (om *ObjectMap) load() error {
-	data, err := os.ReadFile(objectMapFile)
+// putState writes a file's state in tx and moves its index entries from old
+func putState(tx *bolt.Tx, old, state FileState) error {
+	paths, objects := tx.Bucket(bucketPaths), tx.Bucket(bucketObjects)
+	if old.Path != state.Path {
+		unindex(paths, old.Path, state.Key)
+	}
+	if old.ObjectID != state.ObjectID {
+		unindex(objects, old.ObjectID, state.Key)
+	}
+
+	data, err := json.Marshal(state)
 	if err != nil {
 		return err
 	}
+	if err := tx.Bucket(bucketFiles).Put([]byte(state.Key), data); err != nil {
+		return err
+	}
+	if state.Path != "" {
+		if err := paths.Put([]byte(state.Path), []byte(state.Key)); err != nil {
+			return err
+		}
+	}
+	if state.ObjectID != "" {
+		return objects.Put([]byte(state.ObjectID), []byte(state.Key))
+	}
+	return nil
+}
 
-	return json.Unmarshal(data, &om.mapping)
+// unindex removes value from an index bucket while it still points at key
+func unindex(index *bolt.Bucket, value, key string) {
+	if value != "" && string(index.Get([]byte(value))) == key {
+		index.Delete([]byte(value))
+	}
 }
 
-// save writes the object map to disk
-func (om *ObjectMap) save() error {
-	data, err := json.MarshalIndent(om.mapping, "", "  ")
+// openStateDB opens the database at path, creates its buckets and checks it for
+// corruption. Errors caused by damage to the file wrap errStateCorrupt.
+func openStateDB(path string) (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: stateOpenTimeout})
+	if err != nil && isDamaged(path, err) {
+		return nil, fmt.Errorf("%w: %w", errStateCorrupt, err)
+	}
 	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketFiles, bucketPaths, bucketObjects, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		meta := tx.Bucket(bucketMeta)
+		if data := meta.Get(metaVersion); data != nil {
+			var version int
+			if err := json.Unmarshal(data, &version); err != nil {
+				return fmt.Errorf("%w: invalid schema version: %w", errStateCorrupt, err)
+			}
+			if version > stateDBVersion {
+				return fmt.Errorf("state database version %d is newer than this program supports (%d)", version, stateDBVersion)
+			}
+		}
+		if err := meta.Put(metaVersion, []byte(fmt.Sprint(stateDBVersion))); err != nil {
+			return err
+		}
+
+		// Drain every error, or the checker is left blocked sending the next one
+		var first error
+		for err := range tx.Check() {
+			if first == nil {
+				first = fmt.Errorf("%w: %w", errStateCorrupt, err)
+			}
+		}
+		return first
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// isDamaged reports whether bolt refused the database at path because of its content
+func isDamaged(path string, openErr error) bool {
+	if errors.Is(openErr, berrors.ErrInvalid) || errors.Is(openErr, berrors.ErrVersionMismatch) || errors.Is(openErr, berrors.ErrChecksum) {
+		return true
+	}
+
+	// A file cut short before the end of its two meta pages is refused without a
+	// sentinel error; errors from the file system are never damage
+	var pathErr *fs.PathError
+	if errors.As(openErr, &pathErr) {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Size() > 0 && info.Size() < 2*int64(os.Getpagesize())
+}
+
+// recover replaces a corrupted database with the newest backup that opens. The
+// corrupted file is kept next to it for inspection.
+func (om *ObjectMap) recover(openErr error) (*bolt.DB, error) {
+	objectMapLog.Error("State database is corrupted, recovering from backup", "path", om.path, "error", openErr)
+
+	for i := 1; i <= stateBackups; i++ {
+		data, err := os.ReadFile(om.backupPath(i))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				objectMapLog.Warn("Skipping unreadable backup", "path", om.backupPath(i), "error", err)
+			}
+			continue
+		}
+
+		corrupt := fmt.Sprintf("%s.corrupt-%s", om.path, time.Now().Format("20060102-150405"))
+		if err := os.Rename(om.path, corrupt); err != nil {
+			return nil, fmt.Errorf("failed to move corrupted database aside: %w", err)
+		}
+		if err := writeFileAtomic(om.path, data, 0644); err != nil {
+			return nil, err
+		}
+		db, err := openStateDB(om.path)
+		if err != nil {
+			objectMapLog.Warn("Skipping unreadable backup", "path", om.backupPath(i), "error", err)
+			os.Rename(corrupt, om.path)
+			continue
+		}
+
+		objectMapLog.Warn("State database restored from backup; run reconcile to pick up mappings made since",
+			"backup", om.backupPath(i), "corrupted", corrupt)
+		return db, nil
+	}
+	return nil, fmt.Errorf("%w, and no readable backup exists", openErr)
+}
+
+// backupIfDue shifts path.N-1 to path.N ... path.1 to path.2 and writes a copy of
+// the database as path.1, at most once per stateBackupInterval
+func (om *ObjectMap) backupIfDue() {
+	om.backupMu.Lock()
+	defer om.backupMu.Unlock()
+
+	if time.Since(om.lastBackup) < stateBackupInterval {
+		return
+	}
+
+	var data bytes.Buffer
+	err := om.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(&data)
 		return err
+	})
+	if err == nil {
+		for i := stateBackups - 1; i >= 1; i-- {
+			os.Rename(om.backupPath(i), om.backupPath(i+1))
+		}
+		err = writeFileAtomic(om.backupPath(1), data.Bytes(), 0644)
 	}
+	if err != nil {
+		objectMapLog.Warn("Failed to back up state database", "path", om.path, "error", err)
+		return
+	}
+	om.lastBackup = time.Now()
+}
 
-	// Ensure directory exists
-	dir := filepath.Dir(objectMapFile)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+// backupPath returns the path of the nth newest backup
+func (om *ObjectMap) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", om.path, n)
+}
+
+// migrate imports the JSON object map at legacy, falling back to its backups when
+// it is corrupted, and renames it so it is imported once
+func (om *ObjectMap) migrate(legacy string) error {
+	mapping, err := readObjectMap(legacy)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		objectMapLog.Error("Object map is corrupted, migrating its newest readable backup", "path", legacy, "error", err)
+		for i := 1; i <= stateBackups && err != nil; i++ {
+			mapping, err = readObjectMap(fmt.Sprintf("%s.%d", legacy, i))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to migrate object map %s: no readable copy", legacy)
+		}
+	}
+
+	err = om.db.Update(func(tx *bolt.Tx) error {
+		for key, objectID := range mapping {
+			// Keep state recorded since, should an earlier migration have been cut short
+			if _, found, err := getState(tx, key); err != nil || found {
+				continue
+			}
+			if err := putState(tx, FileState{}, FileState{Key: key, ObjectID: objectID}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to migrate object map: %w", err)
+	}
+	if err := os.Rename(legacy, legacy+".migrated"); err != nil {
+		return fmt.Errorf("failed to retire migrated object map: %w", err)
+	}
+
+	objectMapLog.Info("Object map migrated to state database", "from", legacy, "to", om.path, "mappings", len(mapping))
+	return nil
+}
+
+// readObjectMap reads a JSON object map file, in the versioned format or the plain
+// filename -> objectID map written before it had a version
+func readObjectMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	var version int
+	if raw, ok := fields["version"]; ok && json.Unmarshal(raw, &version) == nil {
+		if version > objectMapVersion {
+			return nil, fmt.Errorf("object map version %d is newer than this program supports (%d)", version, objectMapVersion)
+		}
+		var file objectMapData
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		if file.Mappings == nil {
+			return nil, errors.New("object map has no mappings")
+		}
+		return file.Mappings, nil
+	}
+
+	mapping := make(map[string]string)
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// writeFileAtomic replaces path with data so that a crash leaves either the old
+// or the new file, never a partial one
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
 		return err
 	}
 
-	return os.WriteFile(objectMapFile, data, 0644)
+	// Make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
 }
@@ -0,0 +1,198 @@
+package anytypesync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// pausedFile marks a manual pause under the state directory, so it outlasts a
+// restart. It holds the time the pause started.
+const pausedFile = ".anytype-workspace-paused"
+
+// errPaused is returned for files queued because syncing is paused
+var errPaused = errors.New("sync paused")
+
+// Why syncing is paused
+const (
+	PauseManual      = "manual"      // over the control API or by a program
+	PauseMaintenance = "maintenance" // during a maintenance window
+)
+
+// pauseState tracks why syncing is paused and what piled up meanwhile
+type pauseState struct {
+	manual      bool
+	maintenance time.Time // end of the window syncing is paused for; zero outside one
+	skipUntil   time.Time // a window resumed early is not entered again before this
+	pausedFor   string    // reason the current pause started with
+	since       time.Time
+	changes     int // changes queued while paused
+	last        *PauseSummary
+}
+
+// reason says why syncing should be paused, or "" when it should not
+func (p *pauseState) reason() string {
+	switch {
+	case p.manual:
+		return PauseManual
+	case !p.maintenance.IsZero():
+		return PauseMaintenance
+	}
+	return ""
+}
+
+// PauseSummary is what piled up while syncing was paused
+type PauseSummary struct {
+	Reason   string    `json:"reason"`
+	Since    time.Time `json:"since"`
+	Duration Duration  `json:"duration"`
+	Changes  int       `json:"changes"` // file changes seen while paused
+	Syncs    int       `json:"syncs"`   // queued creates and updates, one per file
+	Deletes  int       `json:"deletes"` // queued deletions
+}
+
+// String describes the summary in one line
+func (s PauseSummary) String() string {
+	return fmt.Sprintf("paused %s (%s): %d changes coalesced into %d syncs and %d deletes",
+		time.Duration(s.Duration).Round(time.Second), s.Reason, s.Changes, s.Syncs, s.Deletes)
+}
+
+// Pause stops sending changes to AnyType. The watcher keeps running and queues
+// every change, so nothing is lost; Resume replays the queue. The pause outlasts
+// a restart.
+func (e *Engine) Pause() {
+	e.setPause(func(p *pauseState) { p.manual = true })
+	e.savePause(true)
+}
+
+// Resume sends changes to AnyType again, starting with those queued while paused.
+// During a maintenance window it ends the window early.
+func (e *Engine) Resume() {
+	e.setPause(func(p *pauseState) {
+		p.manual = false
+		if !p.maintenance.IsZero() {
+			engineLog.Info("Maintenance window ended early", "until", p.maintenance)
+			p.skipUntil, p.maintenance = p.maintenance, time.Time{}
+		}
+	})
+	e.savePause(false)
+}
+
+// savePause records whether syncing is paused by hand, for the next start
+func (e *Engine) savePause(manual bool) {
+	path := e.config.statePath(pausedFile)
+	if !manual {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			engineLog.Warn("Failed to remove pause marker", "path", path, "error", err)
+		}
+		return
+	}
+
+	e.pauseMu.Lock()
+	since := e.pause.since
+	e.pauseMu.Unlock()
+	if err := writeFileAtomic(path, []byte(since.Format(time.RFC3339Nano)+"\n"), 0644); err != nil {
+		engineLog.Warn("Failed to save pause marker; the pause ends on restart", "path", path, "error", err)
+	}
+}
+
+// loadPause pauses syncing again if it was paused by hand before a restart
+func (e *Engine) loadPause() {
+	data, err := os.ReadFile(e.config.statePath(pausedFile))
+	if err != nil {
+		return
+	}
+	since, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		since = time.Now()
+	}
+
+	e.pause = pauseState{manual: true, pausedFor: PauseManual, since: since}
+	e.paused.Store(true)
+	engineLog.Info("Sync is still paused from before the restart; run 'anytype-workspace-sync resume' to sync", "since", since)
+}
+
+// Paused reports whether syncing is paused
+func (e *Engine) Paused() bool {
+	return e.paused.Load()
+}
+
+// LastPause returns what piled up during the last pause that has ended, or nil
+func (e *Engine) LastPause() *PauseSummary {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+
+	return e.pause.last
+}
+
+// setPause applies change to the pause state and pauses or resumes syncing to
+// match. On resuming it logs and publishes what piled up.
+func (e *Engine) setPause(change func(*pauseState)) {
+	e.pauseMu.Lock()
+	p := &e.pause
+	change(p)
+	reason := p.reason()
+	if (reason != "") == e.paused.Load() {
+		e.pauseMu.Unlock()
+		return
+	}
+
+	if reason != "" {
+		p.pausedFor, p.since, p.changes = reason, time.Now(), 0
+		e.paused.Store(true)
+		until := p.maintenance
+		e.pauseMu.Unlock()
+
+		if reason == PauseMaintenance {
+			engineLog.Info("Sync paused", "reason", reason, "until", until)
+		} else {
+			engineLog.Info("Sync paused", "reason", reason)
+		}
+		e.publish(Activity{Kind: ActivityPaused, Detail: reason})
+		e.pauseChanged()
+		return
+	}
+
+	summary := &PauseSummary{
+		Reason:   p.pausedFor,
+		Since:    p.since,
+		Duration: Duration(time.Since(p.since).Round(time.Millisecond)),
+		Changes:  p.changes,
+	}
+	for _, op := range e.queue.List() {
+		if op.Op == OpDelete {
+			summary.Deletes++
+		} else {
+			summary.Syncs++
+		}
+	}
+	p.last = summary
+	e.paused.Store(false)
+	e.pauseMu.Unlock()
+
+	engineLog.Info("Sync resumed", "paused_for", time.Duration(summary.Duration), "changes", summary.Changes,
+		"syncs", summary.Syncs, "deletes", summary.Deletes)
+	e.publish(Activity{Kind: ActivityResumed, Detail: summary.String()})
+	e.pauseChanged()
+	e.queue.TriggerReplay()
+}
+
+// pauseChanged makes a pause or resume show in the status file and in systemd
+func (e *Engine) pauseChanged() {
+	if e.running.Load() {
+		e.writeStatus()
+	}
+	e.notifyStatus()
+}
+
+// countPausedChange counts a change queued while paused, for the summary
+func (e *Engine) countPausedChange() {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+
+	if e.paused.Load() {
+		e.pause.changes++
+	}
+}
@@ -0,0 +1,220 @@
+package anytypesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// queueFile is the queue file name under the state directory
+	queueFile = ".anytype-workspace-queue.json"
+
+	// queueReplayInterval is how often the queue is retried without a breaker signal
+	queueReplayInterval = time.Minute
+)
+
+// Pending operations
+const (
+	OpSync   = "sync"
+	OpDelete = "delete"
+)
+
+// PendingOp is a file operation waiting for AnyType to become reachable
+type PendingOp struct {
+	Op       string    `json:"op"`
+	Path     string    `json:"path"`
+	QueuedAt time.Time `json:"queuedAt"`
+}
+
+// PendingQueue holds file operations that could not be sent, coalesced by path so
+// only the latest operation for each file is kept
+type PendingQueue struct {
+	path    string
+	mu      sync.Mutex
+	pending map[string]PendingOp // path -> latest operation
+	replay  chan struct{}
+}
+
+// NewPendingQueue creates a queue stored at path and loads operations left by a previous run
+func NewPendingQueue(path string) (*PendingQueue, error) {
+	q := &PendingQueue{
+		path:    path,
+		pending: make(map[string]PendingOp),
+		replay:  make(chan struct{}, 1),
+	}
+
+	if err := q.load(); err != nil {
+		// If file doesn't exist, that's ok
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to load queue: %w", err)
+		}
+	}
+	queueDepth.Set(float64(len(q.pending)))
+
+	return q, nil
+}
+
+// Add queues an operation, replacing any earlier one for the same path
+func (q *PendingQueue) Add(op, path string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queuedAt := time.Now()
+	if prev, exists := q.pending[path]; exists {
+		queuedAt = prev.QueuedAt
+	}
+	q.pending[path] = PendingOp{Op: op, Path: path, QueuedAt: queuedAt}
+	q.save()
+}
+
+// List returns the queued operations, oldest first
+func (q *PendingQueue) List() []PendingOp {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ops := make([]PendingOp, 0, len(q.pending))
+	for _, op := range q.pending {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].QueuedAt.Before(ops[j].QueuedAt) })
+	return ops
+}
+
+// Len returns the number of queued operations
+func (q *PendingQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
+
+// Drain removes and returns every queued operation, oldest first
+func (q *PendingQueue) Drain() []PendingOp {
+	ops := q.List()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, op := range ops {
+		delete(q.pending, op.Path)
+	}
+	q.save()
+	return ops
+}
+
+// Flush writes the queue to disk
+func (q *PendingQueue) Flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.save()
+}
+
+// TriggerReplay asks the replayer to retry the queue now
+func (q *PendingQueue) TriggerReplay() {
+	select {
+	case q.replay <- struct{}{}:
+	default:
+	}
+}
+
+// load reads the queue from disk
+func (q *PendingQueue) load() error {
+	data, err := os.ReadFile(q.path)
+	if err != nil {
+		return err
+	}
+
+	var ops []PendingOp
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		q.pending[op.Path] = op
+	}
+	return nil
+}
+
+// save writes the queue to disk; callers must hold mu
+func (q *PendingQueue) save() {
+	queueDepth.Set(float64(len(q.pending)))
+
+	ops := make([]PendingOp, 0, len(q.pending))
+	for _, op := range q.pending {
+		ops = append(ops, op)
+	}
+
+	data, err := json.MarshalIndent(ops, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(q.path), 0755)
+	}
+	if err == nil {
+		err = writeFileAtomic(q.path, data, 0644)
+	}
+	if err != nil {
+		queueLog.Warn("Failed to save queue", "path", q.path, "error", err)
+	}
+}
+
+// queueOp records an operation that could not be sent right now
+func (e *Engine) queueOp(op, path string) {
+	e.queue.Add(op, path)
+	e.countPausedChange()
+	queueLog.Info("Operation queued", "op", op, "path", path, "pending", e.queue.Len())
+	e.queued(PendingOp{Op: op, Path: path, QueuedAt: time.Now()})
+}
+
+// Pending returns the operations waiting for AnyType, oldest first
+func (e *Engine) Pending() []PendingOp {
+	return e.queue.List()
+}
+
+// runQueueReplayer replays queued operations whenever the connection recovers,
+// and periodically in case a signal was missed
+func (e *Engine) runQueueReplayer(ctx context.Context) {
+	ticker := time.NewTicker(queueReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		e.ReplayQueue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.queue.replay:
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReplayQueue sends every queued operation. Operations that fail transiently are
+// queued again by SyncFile and DeleteFile themselves.
+func (e *Engine) ReplayQueue(ctx context.Context) {
+	if e.client == nil || e.Paused() || e.queue.Len() == 0 || (e.rpc != nil && !e.rpc.Ready()) {
+		return
+	}
+
+	ops := e.queue.Drain()
+	queueLog.Info("Replaying queued operations", "count", len(ops))
+	ctx = WithTrigger(ctx, TriggerReplay)
+
+	for _, op := range ops {
+		// The file may have come or gone since the operation was queued
+		_, statErr := os.Stat(op.Path)
+		exists := statErr == nil
+
+		switch {
+		case exists:
+			e.SyncFile(ctx, op.Path)
+		case op.Op == OpDelete:
+			e.DeleteFile(ctx, op.Path)
+		default:
+			queueLog.Info("Skipping queued operation, file is gone", "op", op.Op, "path", op.Path)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package anytypesync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Mapping is a single object map entry
+type Mapping struct {
+	Key      string
+	ObjectID string
+	Path     string // file on disk for Key, empty if there is none
+}
+
+// ReconcileReport lists where the object map, the space and the workspace disagree
+type ReconcileReport struct {
+	Dangling []Mapping      // map entries whose object no longer exists in the space
+	Orphans  []RemoteObject // sync-created objects that no map entry points to
+	Missing  []string       // supported files on disk with no map entry
+}
+
+// Repair actions
+const (
+	RepairDropped = "dropped" // a dangling mapping was removed
+	RepairAdopted = "adopted" // an orphan was mapped to its file
+	RepairRemoved = "removed" // an orphan was removed by the delete policy
+	RepairSynced  = "synced"  // a file with no object was synced
+)
+
+// RepairResult is one repair made by reconcile
+type RepairResult struct {
+	Key      string
+	ObjectID string
+	Action   string
+	Err      error // why the repair failed; nil when it was made
+}
+
+// Empty reports whether everything is in agreement
+func (r *ReconcileReport) Empty() bool {
+	return len(r.Dangling) == 0 && len(r.Orphans) == 0 && len(r.Missing) == 0
+}
+
+// Reconcile compares the object map against the space and the workspace directory
+func (e *Engine) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	if e.client == nil {
+		return nil, errNotConnected
+	}
+	dir := e.config.WorkspaceDir
+	entries := e.objects.Entries()
+
+	files, err := workspaceFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspace files: %w", err)
+	}
+	filesByKey := make(map[string]string, len(files))
+	for _, file := range files {
+		filesByKey[e.objectKey(file)] = file
+	}
+
+	// Which mapped objects still exist?
+	ids := make([]string, 0, len(entries))
+	for _, objectID := range entries {
+		ids = append(ids, objectID)
+	}
+	existing, err := e.client.FindObjects(ctx, e.config.SpaceID, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up mapped objects: %w", err)
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, obj := range existing {
+		exists[obj.ID] = true
+	}
+
+	report := &ReconcileReport{}
+	mapped := make(map[string]bool, len(entries))
+	for key, objectID := range entries {
+		mapped[objectID] = true
+		if !exists[objectID] {
+			report.Dangling = append(report.Dangling, Mapping{Key: key, ObjectID: objectID, Path: filesByKey[key]})
+		}
+	}
+	sort.Slice(report.Dangling, func(i, j int) bool { return report.Dangling[i].Key < report.Dangling[j].Key })
+
+	// Which sync-created objects are not in the map?
+	synced, err := e.client.ListSyncedObjects(ctx, e.config.SpaceID, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list synced objects: %w", err)
+	}
+	for _, obj := range synced {
+		// Tombstoned objects are waiting for the sweeper, not orphaned
+		if !mapped[obj.ID] && !e.tombstones.Has(obj.ID) {
+			report.Orphans = append(report.Orphans, obj)
+		}
+	}
+	// Newest first, so repair adopts the most recent copy of a file
+	sort.Slice(report.Orphans, func(i, j int) bool { return report.Orphans[i].Created.After(report.Orphans[j].Created) })
+
+	// Which files on disk have never been synced?
+	for _, file := range files {
+		if _, ok := entries[e.objectKey(file)]; !ok {
+			report.Missing = append(report.Missing, file)
+		}
+	}
+
+	return report, nil
+}
+
+// RepairDangling drops map entries that point at objects which no longer exist.
+// Files still on disk are queued as missing so they get synced again.
+func (e *Engine) RepairDangling(report *ReconcileReport) []RepairResult {
+	var results []RepairResult
+	for _, m := range report.Dangling {
+		err := e.objects.Delete(m.Key)
+		results = append(results, RepairResult{Key: m.Key, ObjectID: m.ObjectID, Action: RepairDropped, Err: err})
+		if err == nil && m.Path != "" {
+			report.Missing = append(report.Missing, m.Path)
+		}
+	}
+	report.Dangling = nil
+	return results
+}
+
+// RepairOrphans adopts orphaned objects whose file is still on disk and unmapped,
+// and removes the rest from AnyType according to the delete policy
+func (e *Engine) RepairOrphans(ctx context.Context, report *ReconcileReport) []RepairResult {
+	ctx = WithTrigger(ctx, TriggerReconcile)
+	var results []RepairResult
+	adopted := make(map[string]bool)
+	for _, obj := range report.Orphans {
+		key := e.objectKey(obj.SourcePath)
+		if _, mapped := e.objects.Get(key); !mapped {
+			if _, err := os.Stat(obj.SourcePath); err == nil {
+				err := e.objects.Set(key, obj.ID)
+				results = append(results, RepairResult{Key: key, ObjectID: obj.ID, Action: RepairAdopted, Err: err})
+				if err == nil {
+					adopted[obj.SourcePath] = true
+				}
+				continue
+			}
+		}
+
+		start := time.Now()
+		entry := AuditEntry{Path: obj.SourcePath, Op: fileOpDelete, ObjectID: obj.ID, Outcome: outcomeSuccess, Detail: "orphan, " + e.config.Delete.Policy}
+		err := e.removeObject(ctx, key, obj.ID, obj.SourcePath)
+		if err != nil {
+			entry.Outcome = outcomeError
+		}
+		e.audit(ctx, start, entry, err)
+		results = append(results, RepairResult{Key: key, ObjectID: obj.ID, Action: RepairRemoved, Err: err})
+	}
+	report.Orphans = nil
+
+	// Adopted files no longer need a fresh object
+	missing := report.Missing[:0]
+	for _, path := range report.Missing {
+		if !adopted[path] {
+			missing = append(missing, path)
+		}
+	}
+	report.Missing = missing
+	return results
+}
+
+// RepairMissing syncs files that have no object yet
+func (e *Engine) RepairMissing(ctx context.Context, report *ReconcileReport) []RepairResult {
+	ctx = WithTrigger(ctx, TriggerReconcile)
+	var results []RepairResult
+	for _, path := range report.Missing {
+		objectID, err := e.SyncFile(ctx, path)
+		results = append(results, RepairResult{Key: e.objectKey(path), ObjectID: objectID, Action: RepairSynced, Err: err})
+	}
+	report.Missing = nil
+	return results
+}
@@ -0,0 +1,130 @@
+package anytypesync
+
+import (
+	"context"
+	"math/rand"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Operation classes, each with its own retry policy
+const (
+	opDefault = "default"
+	opCreate  = "create"
+	opUpdate  = "update"
+	opUpload  = "upload"
+	opDelete  = "delete"
+)
+
+// methodClasses assigns ClientCommands methods to operation classes.
+// Methods not listed use the default policy.
+var methodClasses = map[string]string{
+	"ObjectCreate":            opCreate,
+	"ObjectSetDetails":        opUpdate,
+	"ObjectListSetIsArchived": opUpdate,
+	"ObjectCollectionAdd":     opUpdate,
+	"ObjectCollectionRemove":  opUpdate,
+	"FileUpload":              opUpload,
+	"ObjectListDelete":        opDelete,
+}
+
+// nonIdempotent lists the classes whose calls must not be resent once they may have
+// reached the server: a create that was applied but whose reply was lost would be
+// made twice
+var nonIdempotent = map[string]bool{
+	opCreate: true,
+}
+
+// policy returns the retry policy for a full gRPC method name such as
+// "/anytype.ClientCommands/ObjectCreate"
+func (r RetryConfig) policy(method string) (string, RetryPolicy) {
+	class, ok := methodClasses[path.Base(method)]
+	if !ok {
+		class = opDefault
+	}
+
+	switch class {
+	case opCreate:
+		return class, r.Create
+	case opUpdate:
+		return class, r.Update
+	case opUpload:
+		return class, r.Upload
+	case opDelete:
+		return class, r.Delete
+	default:
+		return class, r.Default
+	}
+}
+
+// retryable reports whether a failed attempt is worth repeating under p. Calls that
+// are not idempotent are repeated only when the server refused them outright.
+func (p RetryPolicy) retryable(err error, idempotent bool) bool {
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Aborted:
+		return true
+	case codes.Unavailable:
+		// The connection may have dropped after the server applied the call
+		return idempotent
+	case codes.DeadlineExceeded:
+		// A timed-out call may still have taken effect on the server
+		return idempotent && p.RetryOnTimeout
+	default:
+		return false
+	}
+}
+
+// backoff returns the delay before the given retry (1 = first retry), with jitter applied
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		delay *= p.Multiplier
+	}
+	if limit := float64(p.MaxBackoff); limit > 0 && delay > limit {
+		delay = limit
+	}
+
+	// Spread retries by ±Jitter so queued operations don't retry in lockstep
+	delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// retryInterceptor applies the retry policy of each call's operation class to every
+// ClientCommands call: a per-attempt deadline, and retries with exponential backoff
+// on transient failures. Creates are not resent after an ambiguous failure; the
+// engine queues them instead. Auth failures are left to withRetry, which can refresh the token.
+func retryInterceptor(policies RetryConfig) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		class, policy := policies.policy(method)
+		idempotent := !nonIdempotent[class]
+
+		for attempt := 1; ; attempt++ {
+			attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+			if policy.AttemptTimeout > 0 {
+				attemptCtx, cancel = context.WithTimeout(ctx, time.Duration(policy.AttemptTimeout))
+			}
+			err := invoker(attemptCtx, method, req, reply, cc, opts...)
+			cancel()
+
+			if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err, idempotent) || ctx.Err() != nil {
+				return err
+			}
+
+			delay := policy.backoff(attempt)
+			apiLog.Warn("RPC attempt failed, retrying", "rpc", path.Base(method), "class", class, "attempt", attempt,
+				"max_attempts", policy.MaxAttempts, "error_code", status.Code(err).String(), "backoff", delay.Round(time.Millisecond))
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
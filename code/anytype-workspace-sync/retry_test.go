@@ -0,0 +1,109 @@
+package anytypesync
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: Duration(500 * time.Millisecond),
+		MaxBackoff:     Duration(10 * time.Second),
+		Multiplier:     2,
+	}
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, 500 * time.Millisecond},
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{5, 8 * time.Second},
+		{6, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.backoff(tt.retry); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.retry, got, tt.want)
+		}
+	}
+
+	// Jitter spreads each delay by up to ±Jitter around the base, and past the cap
+	policy.Jitter = 0.2
+	for _, tt := range tests {
+		low, high := time.Duration(float64(tt.want)*0.8), time.Duration(float64(tt.want)*1.2)
+		seen := map[time.Duration]bool{}
+		for range 200 {
+			got := policy.backoff(tt.retry)
+			if got < low || got > high {
+				t.Fatalf("backoff(%d) = %s, want within [%s, %s]", tt.retry, got, low, high)
+			}
+			seen[got] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("backoff(%d) returned the same delay 200 times; no jitter", tt.retry)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		code       codes.Code
+		idempotent bool
+		onTimeout  bool
+		want       bool
+	}{
+		{codes.Unavailable, true, false, true},
+		{codes.Unavailable, false, false, false},
+		{codes.ResourceExhausted, true, false, true},
+		{codes.ResourceExhausted, false, false, true},
+		{codes.Aborted, false, false, true},
+		{codes.DeadlineExceeded, true, true, true},
+		{codes.DeadlineExceeded, true, false, false},
+		{codes.DeadlineExceeded, false, true, false},
+		{codes.NotFound, true, true, false},
+		{codes.Unauthenticated, true, true, false},
+		{codes.Internal, true, true, false},
+	}
+	for _, tt := range tests {
+		policy := RetryPolicy{RetryOnTimeout: tt.onTimeout}
+		if got := policy.retryable(status.Error(tt.code, "boom"), tt.idempotent); got != tt.want {
+			t.Errorf("retryable(%s, idempotent=%t, retryOnTimeout=%t) = %t, want %t",
+				tt.code, tt.idempotent, tt.onTimeout, got, tt.want)
+		}
+	}
+	if (RetryPolicy{}).retryable(errors.New("no status"), true) {
+		t.Error("an error without a status was retried")
+	}
+}
+
+func TestRetryPolicyClasses(t *testing.T) {
+	config := defaultRetryConfig()
+	for method, want := range map[string]string{
+		"/anytype.ClientCommands/ObjectCreate":     opCreate,
+		"/anytype.ClientCommands/ObjectSetDetails": opUpdate,
+		"/anytype.ClientCommands/FileUpload":       opUpload,
+		"/anytype.ClientCommands/ObjectListDelete": opDelete,
+		"/anytype.ClientCommands/AppGetVersion":    opDefault,
+	} {
+		if class, _ := config.policy(method); class != want {
+			t.Errorf("policy(%s) class = %s, want %s", method, class, want)
+		}
+	}
+
+	c := DefaultConfig()
+	c.WorkspaceDir, c.SpaceID = t.TempDir(), "space"
+	if err := c.validate(); err != nil {
+		t.Fatalf("default config rejected: %v", err)
+	}
+	c.Retry.Create.RetryOnTimeout = true
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "retry.create.retryOnTimeout") {
+		t.Errorf("config retrying timed-out creates: got %v", err)
+	}
+}
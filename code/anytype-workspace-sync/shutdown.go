@@ -0,0 +1,119 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errShuttingDown is returned for files queued because the engine is shutting down
+var errShuttingDown = errors.New("shutting down")
+
+// inflight counts running operations so a shutdown can wait for them
+type inflight struct {
+	mu      sync.Mutex
+	closing bool          // no new operations are admitted
+	done    chan struct{} // closed once closing and nothing runs
+	wg      sync.WaitGroup
+}
+
+// begin admits an operation, unless the engine is shutting down
+func (f *inflight) begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closing {
+		return false
+	}
+	f.wg.Add(1)
+	return true
+}
+
+// close stops admitting operations
+func (f *inflight) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closing {
+		return
+	}
+	f.closing = true
+	done := f.drained()
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+}
+
+// drained returns a channel closed once close was called and the running
+// operations have finished; callers must hold mu
+func (f *inflight) drained() chan struct{} {
+	if f.done == nil {
+		f.done = make(chan struct{})
+	}
+	return f.done
+}
+
+// beginOp admits an operation and returns the context it runs with and a function
+// to call when it is done. While the engine runs, an operation is not cut short
+// when ctx is cancelled: a shutdown lets it finish, and only aborts it once the
+// grace period is over, so an object is never created without being mapped.
+// When the engine is shutting down it returns ctx unchanged and false.
+func (e *Engine) beginOp(ctx context.Context) (context.Context, func(), bool) {
+	if !e.ops.begin() {
+		return ctx, nil, false
+	}
+	if !e.running.Load() {
+		return ctx, e.ops.wg.Done, true
+	}
+
+	opCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(e.abort, cancel)
+	return opCtx, func() {
+		stop()
+		cancel()
+		e.ops.wg.Done()
+	}, true
+}
+
+// aborted reports whether err comes from an operation cut short by shutdown
+func (e *Engine) aborted(err error) bool {
+	return e.abort.Err() != nil && errors.Is(err, context.Canceled)
+}
+
+// beginShutdown stops admitting operations and aborts the running ones once the
+// grace period is over. It runs as soon as Run's ctx is cancelled, so the grace
+// period also bounds the operation the initial sync is waiting for.
+func (e *Engine) beginShutdown() {
+	e.shutdownOnce.Do(func() {
+		grace := time.Duration(e.config.Shutdown.GracePeriod)
+		engineLog.Info("Shutting down, waiting for in-flight operations", "grace_period", grace)
+		e.notify("STOPPING=1")
+
+		e.ops.close()
+		e.graceTimer = time.AfterFunc(grace, func() {
+			engineLog.Warn("Grace period over, aborting in-flight operations", "grace_period", grace)
+			e.abortOps()
+		})
+	})
+}
+
+// shutdown waits for the running operations and saves the queue and object map.
+// Operations started afterwards are queued.
+func (e *Engine) shutdown() {
+	e.beginShutdown()
+
+	e.ops.mu.Lock()
+	drained := e.ops.drained()
+	e.ops.mu.Unlock()
+	<-drained
+	e.graceTimer.Stop()
+
+	e.queue.Flush()
+	if err := e.objects.Flush(); err != nil {
+		engineLog.Error("Failed to save object map", "error", err)
+	}
+	e.closeAudit()
+	engineLog.Info("Shutdown complete", "pending", e.queue.Len())
+}
@@ -0,0 +1,145 @@
+package anytypesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// statusFile is the status file name under the state directory
+	statusFile = ".anytype-workspace-sync.status.json"
+
+	// StatusInterval is how often the running service rewrites its status file
+	StatusInterval = 30 * time.Second
+)
+
+// Status is a snapshot of the running service, written periodically for the "status" subcommand
+type Status struct {
+	UpdatedAt       time.Time     `json:"updatedAt"`
+	PID             int           `json:"pid"`
+	Connected       bool          `json:"connected"`
+	Paused          bool          `json:"paused"`
+	PauseReason     string        `json:"pauseReason,omitempty"`
+	PausedSince     *time.Time    `json:"pausedSince,omitempty"`
+	PausedUntil     *time.Time    `json:"pausedUntil,omitempty"` // end of the maintenance window
+	PausedChanges   int           `json:"pausedChanges,omitempty"`
+	LastPause       *PauseSummary `json:"lastPause,omitempty"`
+	SpaceID         string        `json:"spaceId"`
+	Mappings        int           `json:"mappings"`
+	TokenExpiry     *time.Time    `json:"tokenExpiry,omitempty"`
+	DeletionsPaused string        `json:"deletionsPaused,omitempty"`
+	HeldDeletions   int           `json:"heldDeletions"`
+	Breaker         string        `json:"breaker"`
+	FailureRate     float64       `json:"failureRate"`
+	QueueDepth      int           `json:"queueDepth"`
+	Health          string        `json:"health"`
+	HealthSince     time.Time     `json:"healthSince"`
+	HealthError     string        `json:"healthError,omitempty"`
+	Supervised      bool          `json:"supervised"`
+	ServerPID       int           `json:"serverPid,omitempty"`
+	ServerRestarts  int           `json:"serverRestarts,omitempty"`
+}
+
+// Status gathers the current state of the engine
+func (e *Engine) Status() Status {
+	status := Status{
+		UpdatedAt:  time.Now(),
+		PID:        os.Getpid(),
+		Connected:  e.client != nil,
+		Paused:     e.Paused(),
+		SpaceID:    e.config.SpaceID,
+		Mappings:   e.objects.Len(),
+		QueueDepth: e.queue.Len(),
+	}
+	status.DeletionsPaused, status.HeldDeletions = e.guard.State()
+
+	e.pauseMu.Lock()
+	if status.Paused {
+		since := e.pause.since
+		status.PauseReason, status.PausedSince, status.PausedChanges = e.pause.pausedFor, &since, e.pause.changes
+		if until := e.pause.maintenance; !until.IsZero() {
+			status.PausedUntil = &until
+		}
+	}
+	status.LastPause = e.pause.last
+	e.pauseMu.Unlock()
+
+	if e.client == nil {
+		status.Health = HealthDown.String()
+	}
+	if e.rpc == nil {
+		return status
+	}
+
+	if expiry, ok := e.rpc.TokenExpiry(); ok {
+		status.TokenExpiry = &expiry
+	}
+	var state BreakerState
+	state, status.FailureRate = e.rpc.BreakerState()
+	status.Breaker = state.String()
+
+	health, since, healthErr := e.rpc.Health()
+	status.Health, status.HealthSince = health.String(), since
+	if healthErr != nil {
+		status.HealthError = healthErr.Error()
+	}
+
+	if e.rpc.supervisor != nil {
+		status.Supervised = true
+		status.ServerPID, status.ServerRestarts = e.rpc.supervisor.State()
+	}
+	return status
+}
+
+// writeStatus saves the current status for the "status" subcommand
+func (e *Engine) writeStatus() {
+	data, err := json.MarshalIndent(e.Status(), "", "  ")
+	if err == nil {
+		err = os.WriteFile(e.config.statePath(statusFile), data, 0644)
+	}
+	if err != nil {
+		statusLog.Warn("Failed to write status", "error", err)
+	}
+}
+
+// runStatusWriter keeps the status file current until ctx is cancelled
+func (e *Engine) runStatusWriter(ctx context.Context) {
+	ticker := time.NewTicker(StatusInterval)
+	defer ticker.Stop()
+
+	for {
+		e.writeStatus()
+		e.notifyStatus()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReadStatus reads the status last written by the service running with config
+func ReadStatus(config *Config) (*Status, error) {
+	data, err := os.ReadFile(config.statePath(statusFile))
+	if err != nil {
+		return nil, fmt.Errorf("no status available (is the service running?): %w", err)
+	}
+
+	var status Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status: %w", err)
+	}
+	return &status, nil
+}
+
+// FormatAge renders how long ago t was, for log and command output
+func FormatAge(t time.Time) string {
+	if t.IsZero() || t.Unix() == 0 {
+		return "unknown"
+	}
+	return time.Since(t).Round(time.Minute).String() + " ago"
+}
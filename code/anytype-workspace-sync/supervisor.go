@@ -0,0 +1,316 @@
+package anytypesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// stableRun is how long the server must stay up before its restart backoff resets
+const stableRun = 5 * time.Minute
+
+// Supervisor runs "anytype serve" as a child process: it starts it, restarts it
+// with backoff when it exits, sends its output to a rotated log file and stops
+// it when the service shuts down.
+type Supervisor struct {
+	config SupervisorConfig
+	binary string
+	addr   string
+	log    *RotatingFile
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	exited    chan struct{} // closed when cmd exits
+	startedAt time.Time
+	restarts  int
+	restart   bool          // the running process is being restarted on request
+	stopped   chan struct{} // closed when Run has stopped the server and returned
+}
+
+// NewSupervisor creates a supervisor for binary, serving gRPC on addr
+func NewSupervisor(config SupervisorConfig, binary string, addr string) (*Supervisor, error) {
+	log, err := OpenRotatingFile(config.LogFile, int64(config.LogMaxSizeMB)<<20, config.LogBackups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open server log: %w", err)
+	}
+	return &Supervisor{
+		config:  config,
+		binary:  binary,
+		addr:    addr,
+		log:     log,
+		stopped: make(chan struct{}),
+	}, nil
+}
+
+// Run keeps the server running until ctx is cancelled, then stops it
+func (s *Supervisor) Run(ctx context.Context) {
+	defer close(s.stopped)
+	defer s.log.Close()
+
+	if portOpen(s.addr) {
+		supervisorLog.Warn("Address already in use; stop the unsupervised anytype server so the supervised one can bind", "addr", s.addr)
+	}
+
+	backoff := time.Duration(s.config.InitialBackoff)
+	for {
+		exited, err := s.start()
+		if err != nil {
+			supervisorLog.Error("Failed to start anytype server", "error", err)
+		} else {
+			select {
+			case <-exited:
+			case <-ctx.Done():
+				s.stop()
+				return
+			}
+		}
+
+		s.mu.Lock()
+		requested, ranFor := s.restart, time.Since(s.startedAt)
+		s.restart = false
+		if err == nil {
+			s.restarts++
+		}
+		s.mu.Unlock()
+
+		if requested {
+			supervisorLog.Info("Restarting anytype server on request")
+			continue
+		}
+
+		if err == nil {
+			if ranFor >= stableRun {
+				backoff = time.Duration(s.config.InitialBackoff)
+			}
+			supervisorLog.Error("anytype server exited", "ran_for", ranFor.Round(time.Second), "status", s.exitStatus())
+		}
+		supervisorLog.Info("Restarting anytype server", "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Duration(s.config.MaxBackoff))
+	}
+}
+
+// start launches the server and returns a channel closed when it exits
+func (s *Supervisor) start() (<-chan struct{}, error) {
+	cmd := exec.Command(s.binary, s.config.Args...)
+	cmd.Stdout = s.log
+	cmd.Stderr = s.log
+	// Own process group so the whole server can be signalled; die with the daemon
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGTERM}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	supervisorLog.Info("Started anytype server", "pid", cmd.Process.Pid, "log_file", s.config.LogFile)
+
+	exited := make(chan struct{})
+	s.mu.Lock()
+	s.cmd = cmd
+	s.exited = exited
+	s.startedAt = time.Now()
+	s.mu.Unlock()
+
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	return exited, nil
+}
+
+// exitStatus describes how the last server process ended
+func (s *Supervisor) exitStatus() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd == nil || s.cmd.ProcessState == nil {
+		return "unknown"
+	}
+	return s.cmd.ProcessState.String()
+}
+
+// stop sends SIGTERM to the server's process group and SIGKILL if it outlives StopTimeout
+func (s *Supervisor) stop() {
+	s.mu.Lock()
+	cmd, exited := s.cmd, s.exited
+	s.mu.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+
+	supervisorLog.Info("Stopping anytype server", "pid", cmd.Process.Pid)
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+
+	select {
+	case <-exited:
+		supervisorLog.Info("anytype server stopped")
+	case <-time.After(time.Duration(s.config.StopTimeout)):
+		supervisorLog.Warn("anytype server did not stop in time, killing it", "timeout", time.Duration(s.config.StopTimeout))
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+	}
+}
+
+// Restart stops the running server, lets Run start a new one and waits until it is ready
+func (s *Supervisor) Restart(ctx context.Context) error {
+	if pid, _ := s.State(); pid != 0 {
+		s.mu.Lock()
+		s.restart = true
+		s.mu.Unlock()
+
+		s.stop()
+	}
+	return s.WaitReady(ctx)
+}
+
+// restartTimeout bounds a Restart: stopping the old server and waiting for the new one
+func (s *Supervisor) restartTimeout() time.Duration {
+	return time.Duration(s.config.StopTimeout) + time.Duration(s.config.ReadyTimeout)
+}
+
+// WaitReady waits until the server accepts connections on its gRPC port
+func (s *Supervisor) WaitReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.config.ReadyTimeout))
+	defer cancel()
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if portOpen(s.addr) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("anytype server not listening on %s after %s", s.addr, time.Duration(s.config.ReadyTimeout))
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stopped is closed once Run has stopped the server after ctx was cancelled
+func (s *Supervisor) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
+// State returns the current server pid (0 when not running) and how often it was restarted
+func (s *Supervisor) State() (pid int, restarts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd != nil && s.cmd.Process != nil {
+		select {
+		case <-s.exited:
+		default:
+			pid = s.cmd.Process.Pid
+		}
+	}
+	return pid, s.restarts
+}
+
+// portOpen reports whether something accepts TCP connections on addr
+func portOpen(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// RotatingFile is an append-only log file that is rotated to path.1 ... path.N
+// once it grows past maxSize bytes
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+// OpenRotatingFile opens path for appending
+func OpenRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the current log file; callers must hold mu or own r exclusively
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if it would push the file past maxSize
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path.N-1 to path.N ... path to path.1 and reopens path; callers must hold mu
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+	r.file = nil
+
+	if r.backups > 0 {
+		for i := r.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+// Close closes the log file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,116 @@
+package anytypesync
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// notifier reports service state to systemd over $NOTIFY_SOCKET, as sd_notify(3)
+// does. Without the variable, i.e. outside a Type=notify unit, it does nothing.
+type notifier struct {
+	addr     *net.UnixAddr
+	watchdog time.Duration // WatchdogSec of the unit; 0 when the watchdog is off
+
+	mu       sync.Mutex
+	lastPing time.Time
+}
+
+// newNotifier reads the socket and watchdog settings systemd passes in the environment
+func newNotifier() *notifier {
+	n := &notifier{}
+
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return n
+	}
+	// A leading @ names a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	n.addr = &net.UnixAddr{Name: socket, Net: "unixgram"}
+
+	// The watchdog applies to the main process only
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return n
+	}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		n.watchdog = time.Duration(usec) * time.Microsecond
+	}
+	return n
+}
+
+// send hands state, one or more VAR=value lines, to systemd
+func (n *notifier) send(state string) {
+	if n.addr == nil {
+		return
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err == nil {
+		_, err = conn.Write([]byte(state))
+		conn.Close()
+	}
+	if err != nil {
+		engineLog.Warn("Failed to notify systemd", "error", err)
+	}
+}
+
+// ping tells the watchdog the main loop is alive, at most every half interval
+func (n *notifier) ping() {
+	if n.watchdog == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	due := time.Since(n.lastPing) >= n.watchdog/2
+	if due {
+		n.lastPing = time.Now()
+	}
+	n.mu.Unlock()
+
+	if due {
+		n.send("WATCHDOG=1")
+	}
+}
+
+// notify sends state to systemd while the engine runs as the service
+func (e *Engine) notify(state string) {
+	if e.running.Load() {
+		e.systemd.send(state)
+	}
+}
+
+// alive pings the systemd watchdog; called from the loops whose hang it should catch
+func (e *Engine) alive() {
+	if e.running.Load() {
+		e.systemd.ping()
+	}
+}
+
+// notifyStatus shows the connection state and queue depth in "systemctl status"
+func (e *Engine) notifyStatus() {
+	e.notify("STATUS=" + e.Status().summary())
+}
+
+// summary describes the status in one line
+func (s Status) summary() string {
+	if !s.Connected {
+		return fmt.Sprintf("Not connected to AnyType, %d queued", s.QueueDepth)
+	}
+
+	state := "Syncing"
+	if s.Paused {
+		state = "Paused"
+		if s.PausedUntil != nil {
+			state += " for maintenance until " + s.PausedUntil.Format("15:04")
+		}
+	}
+	if s.Health != "" {
+		state += ", AnyType " + s.Health
+	}
+	return fmt.Sprintf("%s, %d queued", state, s.QueueDepth)
+}
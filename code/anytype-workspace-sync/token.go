@@ -0,0 +1,220 @@
+package anytypesync
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// renewalWait caps how long a call waits for an in-flight token renewal
+	renewalWait = 10 * time.Second
+
+	// renewalRetry is how long to wait before retrying a failed proactive renewal
+	renewalRetry = 30 * time.Second
+
+	// minRenewalInterval stops renewal loops when a fresh token is rejected at once
+	minRenewalInterval = 30 * time.Second
+)
+
+// tokenExpiry decodes the exp claim of a JWT session token. The signature is not
+// checked; the server does that. It returns false for tokens without an exp claim.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// tokenManager hands out the session token and renews it. The provider's token is
+// used until a renewal replaces it, and again as soon as the provider's changes.
+// Renewals are single-flight: callers that find a renewal in flight wait for it
+// and share its result.
+type tokenManager struct {
+	provider TokenProvider
+
+	mu          sync.Mutex
+	seen        string   // provider token at the last look
+	current     string   // token sent with calls
+	renewal     *renewal // in-flight renewal, nil when none
+	lastRenewal time.Time
+	lastErr     error // result of the last renewal
+}
+
+// renewal is one in-flight token renewal
+type renewal struct {
+	done chan struct{} // closed when the renewal finishes
+	err  error
+}
+
+// newTokenManager starts with the provider's current token
+func newTokenManager(provider TokenProvider) *tokenManager {
+	m := &tokenManager{provider: provider}
+	m.sync()
+	return m
+}
+
+// sync switches to the provider's token if it changed; callers must hold mu
+func (m *tokenManager) sync() {
+	if token := m.provider.Token(); token != m.seen {
+		m.seen = token
+		if token != "" {
+			m.current = token
+		}
+	}
+}
+
+// peek returns the current token without waiting for a renewal
+func (m *tokenManager) peek() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sync()
+	return m.current
+}
+
+// get returns the current token, waiting briefly if a renewal is in flight
+func (m *tokenManager) get(ctx context.Context) string {
+	m.mu.Lock()
+	r := m.renewal
+	m.mu.Unlock()
+
+	if r != nil {
+		timer := time.NewTimer(renewalWait)
+		defer timer.Stop()
+		select {
+		case <-r.done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return m.peek()
+}
+
+// renew replaces stale, the token a caller saw rejected, with one from fn. It
+// returns at once if the token has already moved on, joins a renewal in flight,
+// and refuses to renew again within minRenewalInterval of the last attempt.
+func (m *tokenManager) renew(ctx context.Context, stale string, fn func(context.Context) (string, error)) error {
+	m.mu.Lock()
+	m.sync()
+	if m.current != stale {
+		// Renewed by someone else, or the provider has a new token
+		m.mu.Unlock()
+		return nil
+	}
+	if r := m.renewal; r != nil {
+		m.mu.Unlock()
+		select {
+		case <-r.done:
+			return r.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if time.Since(m.lastRenewal) < minRenewalInterval {
+		// The last renewal failed, or its token was rejected already
+		err := m.lastErr
+		m.mu.Unlock()
+		if err == nil {
+			err = fmt.Errorf("token refresh rate limit")
+		}
+		return err
+	}
+
+	r := &renewal{done: make(chan struct{})}
+	m.renewal = r
+	m.mu.Unlock()
+
+	token, err := fn(ctx)
+	countRenewal(err)
+
+	m.mu.Lock()
+	if err == nil {
+		m.current = token
+	}
+	m.lastRenewal, m.lastErr = time.Now(), err
+	m.renewal = nil
+	r.err = err
+	m.mu.Unlock()
+
+	close(r.done)
+	return err
+}
+
+// SetTokenProvider replaces where the client gets its session token from. Call it
+// before the client is used.
+func (c *AnyTypeClient) SetTokenProvider(provider TokenProvider) {
+	c.tokens = newTokenManager(provider)
+}
+
+// token returns the current session token, waiting briefly if a renewal is in flight
+func (c *AnyTypeClient) token(ctx context.Context) string {
+	return c.tokens.get(ctx)
+}
+
+// TokenExpiry returns when the current session token expires, if it says
+func (c *AnyTypeClient) TokenExpiry() (time.Time, bool) {
+	return tokenExpiry(c.tokens.peek())
+}
+
+// RunTokenRenewal renews the session token ahead of its expiry so calls never
+// have to fail with an expired token first
+func (c *AnyTypeClient) RunTokenRenewal(ctx context.Context, renewBefore time.Duration) {
+	for {
+		wait := renewalRetry
+		if expiry, ok := c.TokenExpiry(); ok {
+			wait = time.Until(expiry.Add(-renewBefore))
+			authLog.Info("Session token expiry", "expires_in", FormatRemaining(expiry), "renew_in", wait.Round(time.Second))
+		}
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		// Tokens without an exp claim are only renewed when a call fails
+		if _, ok := c.TokenExpiry(); !ok {
+			continue
+		}
+
+		if err := c.refreshToken(ctx, c.tokens.peek()); err != nil {
+			authLog.Error("Proactive token renewal failed", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(renewalRetry):
+			}
+		}
+	}
+}
+
+// FormatRemaining renders the time left until t, or how long ago it passed
+func FormatRemaining(t time.Time) string {
+	remaining := time.Until(t).Round(time.Second)
+	if remaining < 0 {
+		return fmt.Sprintf("expired %s ago", -remaining)
+	}
+	return remaining.String()
+}
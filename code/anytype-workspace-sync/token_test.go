@@ -0,0 +1,39 @@
+package anytypesync
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	jwt := func(payload string) string {
+		return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  time.Time
+		ok    bool
+	}{
+		{"exp claim", jwt(`{"exp": 1767225600, "sub": "x"}`), time.Unix(1767225600, 0), true},
+		{"padded payload", "h." + base64.URLEncoding.EncodeToString([]byte(`{"exp":1700000000}`)) + ".s", time.Unix(1700000000, 0), true},
+		{"no exp claim", jwt(`{"sub": "x"}`), time.Time{}, false},
+		{"zero exp", jwt(`{"exp": 0}`), time.Time{}, false},
+		{"exp not a number", jwt(`{"exp": "soon"}`), time.Time{}, false},
+		{"payload not JSON", jwt(`not json`), time.Time{}, false},
+		{"payload not base64", "h.!!!.s", time.Time{}, false},
+		{"two parts", "h." + base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1}`)), time.Time{}, false},
+		{"opaque token", "2a1f0c9e-session", time.Time{}, false},
+		{"empty", "", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tokenExpiry(tt.token)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("tokenExpiry = %v, %t; want %v, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
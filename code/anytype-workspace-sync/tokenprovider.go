@@ -0,0 +1,165 @@
+package anytypesync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// tokenEnv names the environment variable that can supply the session token
+const tokenEnv = "ANYTYPE_SYNC_TOKEN"
+
+// TokenProvider supplies the session token from outside the client. Token is
+// called for every request, so it must be cheap. When the token it returns
+// changes, the client switches to it, even if it renewed the token in between.
+type TokenProvider interface {
+	Token() string
+}
+
+// StaticToken is a TokenProvider for a fixed token
+type StaticToken string
+
+// Token returns the fixed token
+func (t StaticToken) Token() string {
+	return string(t)
+}
+
+// EnvToken is a TokenProvider that reads the token from the named environment variable
+type EnvToken string
+
+// Token returns the variable's current value
+func (e EnvToken) Token() string {
+	return os.Getenv(string(e))
+}
+
+// FileToken is a TokenProvider that reads the token from a file and reloads it
+// whenever the file is written or replaced
+type FileToken struct {
+	path    string
+	parse   func([]byte) (string, error)
+	watcher *fsnotify.Watcher
+
+	mu    sync.RWMutex
+	token string
+}
+
+// NewFileToken watches a file that holds nothing but the token
+func NewFileToken(path string) (*FileToken, error) {
+	return newFileToken(path, func(data []byte) (string, error) {
+		return strings.TrimSpace(string(data)), nil
+	})
+}
+
+// NewCLIConfigToken watches the config file the anytype CLI writes, usually
+// ~/.anytype/config.json, which "anytype serve" rewrites with a new token on start
+func NewCLIConfigToken(path string) (*FileToken, error) {
+	return newFileToken(path, func(data []byte) (string, error) {
+		var config struct {
+			SessionToken string `json:"sessionToken"`
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return "", fmt.Errorf("failed to parse config: %w", err)
+		}
+		return config.SessionToken, nil
+	})
+}
+
+// newFileToken loads the file and starts watching it. A file that cannot be read
+// yet is not an error; it is picked up once it appears, provided its directory exists.
+func newFileToken(path string, parse func([]byte) (string, error)) (*FileToken, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token file watcher: %w", err)
+	}
+
+	f := &FileToken{path: path, parse: parse, watcher: watcher}
+	f.reload()
+
+	// Watch the directory, since editors and servers often replace the file
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		authLog.Warn("Not watching token file for new tokens", "path", path, "error", err)
+	}
+	go f.watch()
+	return f, nil
+}
+
+// Token returns the token last read from the file
+func (f *FileToken) Token() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.token
+}
+
+// Close stops watching the file
+func (f *FileToken) Close() error {
+	return f.watcher.Close()
+}
+
+// watch reloads the token whenever the file changes
+func (f *FileToken) watch() {
+	for {
+		select {
+		case event, ok := <-f.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == filepath.Clean(f.path) && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				f.reload()
+			}
+		case err, ok := <-f.watcher.Errors:
+			if !ok {
+				return
+			}
+			watcherErrors.WithLabelValues("token").Inc()
+			authLog.Warn("Token file watcher error", "error", err)
+		}
+	}
+}
+
+// reload reads the file and keeps the previous token when that fails, so a
+// half-written file does not blank the token
+func (f *FileToken) reload() {
+	data, err := os.ReadFile(f.path)
+	if err == nil {
+		var token string
+		token, err = f.parse(data)
+		if err == nil && token == "" {
+			err = fmt.Errorf("no token in file")
+		}
+		if err == nil {
+			f.mu.Lock()
+			changed := token != f.token
+			f.token = token
+			f.mu.Unlock()
+
+			if changed {
+				authLog.Info("Session token loaded", "path", f.path)
+			}
+			return
+		}
+	}
+	authLog.Warn("Failed to read session token", "path", f.path, "error", err)
+}
+
+// newTokenProvider picks the token source from the config: $ANYTYPE_SYNC_TOKEN,
+// then auth.tokenFile, then the anytype CLI config
+func newTokenProvider(config AuthConfig) (TokenProvider, error) {
+	if os.Getenv(tokenEnv) != "" {
+		return EnvToken(tokenEnv), nil
+	}
+	if config.TokenFile != "" {
+		return NewFileToken(config.TokenFile)
+	}
+	return NewCLIConfigToken(cliConfigPath())
+}
+
+// cliConfigPath is where the anytype CLI keeps its config and session token
+func cliConfigPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".anytype", "config.json")
+}